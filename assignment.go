@@ -0,0 +1,141 @@
+package main
+
+import (
+	"k8s.io/api/extensions/v1alpha1"
+)
+
+// pendingClaim is the requirement of an extendedresourceclaim of another pod waiting for extended resources
+type pendingClaim struct {
+	rawResourceName string
+	selector        *propertySelector
+}
+
+// extendedResourceDemand is the cost of giving an extended resource to a claim, the number of pending
+// claims of other pods it could satisfy. Assignments which use the extended resources fewest pending
+// claims can use are preferred, so the scarce ones are kept free for the claims which need them.
+func extendedResourceDemand(er *v1alpha1.ExtendedResource, pending []pendingClaim) int {
+	demand := 0
+	for _, claim := range pending {
+		if claim.rawResourceName == er.Spec.RawResourceName && claim.selector.Matches(er.Spec.Properties) {
+			demand++
+		}
+	}
+	return demand
+}
+
+// assignExtendedResources solves the assignment of extended resources to the claims of a pod
+// as a min cost flow problem. needs[i] is the number of extended resources claim i needs,
+// candidates[i] are the indexes of the extended resources claim i can use and costs[j] is the
+// cost of using extended resource j. Every extended resource is given to at most one claim.
+//
+// A valid assignment is always found if one exists, and among the valid assignments the one with
+// the lowest total cost is returned. If no valid assignment exists, ok is false and unsatisfied is
+// the index of a claim which can not get enough extended resources.
+func assignExtendedResources(needs []int, candidates [][]int, costs []int) (assignment [][]int, unsatisfied int, ok bool) {
+	claimNum, erNum := len(needs), len(costs)
+	// vertexes: source, claims, extended resources, sink
+	source, sink := 0, claimNum+erNum+1
+	g := newFlowGraph(sink + 1)
+	total := 0
+	for i, need := range needs {
+		g.addEdge(source, 1+i, need, 0)
+		total += need
+		for _, j := range candidates[i] {
+			g.addEdge(1+i, 1+claimNum+j, 1, costs[j])
+		}
+	}
+	for j := range costs {
+		g.addEdge(1+claimNum+j, sink, 1, 0)
+	}
+
+	flow := g.minCostFlow(source, sink, total)
+
+	assignment = make([][]int, claimNum)
+	got := make([]int, claimNum)
+	for i := range needs {
+		for _, e := range g.edges[1+i] {
+			if e.to > claimNum && e.to < sink && e.capacity == 0 {
+				assignment[i] = append(assignment[i], e.to-1-claimNum)
+				got[i]++
+			}
+		}
+	}
+	if flow < total {
+		for i, need := range needs {
+			if got[i] < need {
+				return nil, i, false
+			}
+		}
+	}
+	return assignment, -1, true
+}
+
+type flowEdge struct {
+	to, capacity, cost int
+	// reverse is the index of the reverse edge in edges[to]
+	reverse int
+}
+
+// flowGraph is a flow network with edge costs
+type flowGraph struct {
+	edges [][]flowEdge
+}
+
+func newFlowGraph(n int) *flowGraph {
+	return &flowGraph{edges: make([][]flowEdge, n)}
+}
+
+func (g *flowGraph) addEdge(from, to, capacity, cost int) {
+	g.edges[from] = append(g.edges[from], flowEdge{to: to, capacity: capacity, cost: cost, reverse: len(g.edges[to])})
+	g.edges[to] = append(g.edges[to], flowEdge{to: from, capacity: 0, cost: -cost, reverse: len(g.edges[from]) - 1})
+}
+
+// minCostFlow sends up to maxFlow units from source to sink along the cheapest augmenting paths
+// and returns the flow actually sent. The graphs built here are tiny, so Bellman-Ford is used to
+// find the shortest paths since it copes with the negative costs of reverse edges.
+func (g *flowGraph) minCostFlow(source, sink, maxFlow int) int {
+	n := len(g.edges)
+	flow := 0
+	for flow < maxFlow {
+		dist := make([]int, n)
+		reached := make([]bool, n)
+		prevVertex := make([]int, n)
+		prevEdge := make([]int, n)
+		reached[source] = true
+		for updated := true; updated; {
+			updated = false
+			for u := 0; u < n; u++ {
+				if !reached[u] {
+					continue
+				}
+				for k, e := range g.edges[u] {
+					if e.capacity > 0 && (!reached[e.to] || dist[u]+e.cost < dist[e.to]) {
+						dist[e.to] = dist[u] + e.cost
+						reached[e.to] = true
+						prevVertex[e.to] = u
+						prevEdge[e.to] = k
+						updated = true
+					}
+				}
+			}
+		}
+		if !reached[sink] {
+			break
+		}
+
+		// every path from source to sink passes an edge to the sink with capacity 1
+		augment := maxFlow - flow
+		for v := sink; v != source; v = prevVertex[v] {
+			if c := g.edges[prevVertex[v]][prevEdge[v]].capacity; c < augment {
+				augment = c
+			}
+		}
+		for v := sink; v != source; v = prevVertex[v] {
+			e := &g.edges[prevVertex[v]][prevEdge[v]]
+			e.capacity -= augment
+			g.edges[v][e.reverse].capacity += augment
+		}
+		flow += augment
+	}
+	return flow
+}
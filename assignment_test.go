@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/extensions/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestAllocateExtendedResources(t *testing.T) {
+	k80 := map[string]string{"model": "k80"}
+	nvlink := map[string]string{"model": "k80", "nvlink": "true"}
+
+	tests := []struct {
+		name     string
+		ers      []*v1alpha1.ExtendedResource
+		claims   []*v1alpha1.ExtendedResourceClaim
+		pending  []*v1alpha1.ExtendedResourceClaim // claims of other pods waiting for extended resources
+		expected allocationPlan
+		reason   string
+	}{
+		{
+			name:   "loose claim does not take the only resource of a strict claim",
+			ers:    []*v1alpha1.ExtendedResource{newTestER("er1", nvlink), newTestER("er2", k80)},
+			claims: []*v1alpha1.ExtendedResourceClaim{newTestClaim("any", 1, k80), newTestClaim("linked", 1, map[string]string{"nvlink": "true"})},
+			expected: allocationPlan{
+				"any":    {"er2"},
+				"linked": {"er1"},
+			},
+		},
+		{
+			name:     "resources wanted by pending claims are kept free",
+			ers:      []*v1alpha1.ExtendedResource{newTestER("er1", nvlink), newTestER("er2", k80), newTestER("er3", k80)},
+			claims:   []*v1alpha1.ExtendedResourceClaim{newTestClaim("any", 2, k80)},
+			pending:  []*v1alpha1.ExtendedResourceClaim{newTestClaim("other", 1, map[string]string{"nvlink": "true"})},
+			expected: allocationPlan{"any": {"er2", "er3"}},
+		},
+		{
+			name:     "same number of properties, the one a pending claim needs is kept free",
+			ers:      []*v1alpha1.ExtendedResource{newTestER("er1", map[string]string{"model": "large"}), newTestER("er2", map[string]string{"model": "small"})},
+			claims:   []*v1alpha1.ExtendedResourceClaim{newTestClaim("any", 1, nil)},
+			pending:  []*v1alpha1.ExtendedResourceClaim{newTestClaim("large", 1, map[string]string{"model": "large"})},
+			expected: allocationPlan{"any": {"er2"}},
+		},
+		{
+			name:     "same number of properties, the other one kept free",
+			ers:      []*v1alpha1.ExtendedResource{newTestER("er1", map[string]string{"model": "large"}), newTestER("er2", map[string]string{"model": "small"})},
+			claims:   []*v1alpha1.ExtendedResourceClaim{newTestClaim("any", 1, nil)},
+			pending:  []*v1alpha1.ExtendedResourceClaim{newTestClaim("other", 1, map[string]string{"model": "small"})},
+			expected: allocationPlan{"any": {"er1"}},
+		},
+		{
+			name:   "no valid assignment",
+			ers:    []*v1alpha1.ExtendedResource{newTestER("er1", nvlink), newTestER("er2", k80)},
+			claims: []*v1alpha1.ExtendedResourceClaim{newTestClaim("any", 1, k80), newTestClaim("linked", 2, map[string]string{"nvlink": "true"})},
+			reason: "not enough extended resources matching linked",
+		},
+		{
+			name:   "claims share the only matching resource",
+			ers:    []*v1alpha1.ExtendedResource{newTestER("er1", k80)},
+			claims: []*v1alpha1.ExtendedResourceClaim{newTestClaim("first", 1, k80), newTestClaim("second", 1, k80)},
+			reason: "not enough extended resources for second",
+		},
+		{
+			name:   "matching resources are bound",
+			ers:    []*v1alpha1.ExtendedResource{withPhase(newTestER("er1", k80), v1alpha1.ExtendedResourceBound), newTestER("er2", nvlink)},
+			claims: []*v1alpha1.ExtendedResourceClaim{newTestClaim("any", 2, k80)},
+			reason: "er1 bound",
+		},
+		{
+			name: "no resource of the raw resource name",
+			ers:  []*v1alpha1.ExtendedResource{newTestER("er1", k80)},
+			claims: []*v1alpha1.ExtendedResourceClaim{func() *v1alpha1.ExtendedResourceClaim {
+				erc := newTestClaim("fpga", 1, nil)
+				erc.Spec.RawResourceName = "example.com/fpga"
+				return erc
+			}()},
+			reason: "not enough extended resources of example.com/fpga for fpga",
+		},
+	}
+	for _, test := range tests {
+		node := newTestNode("node1")
+		objs := make([]runtime.Object, 0, len(test.ers))
+		for _, er := range test.ers {
+			objs = append(objs, er)
+			node.Status.ExtendedResourceAllocatable = append(node.Status.ExtendedResourceAllocatable, er.Name)
+		}
+		for _, erc := range test.pending {
+			objs = append(objs, erc)
+		}
+		extendedResourceScheduler := &ExtendedResourceScheduler{Cache: newTestCache(objs...)}
+
+		plan, reason := allocateExtendedResources("pod1", test.claims, *node, extendedResourceScheduler)
+		message := ""
+		if reason != nil {
+			message = reason.String()
+		}
+		if message != test.reason {
+			t.Errorf("%s: expected reason %q, got %q", test.name, test.reason, message)
+		}
+		if test.expected != nil && !reflect.DeepEqual(plan, test.expected) {
+			t.Errorf("%s: expected plan %v, got %v", test.name, test.expected, plan)
+		}
+	}
+}
@@ -3,20 +3,35 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
 )
 
+const (
+	claimBoundReason = "ExtendedResourceClaim is already bound to ExtendedResource"
+
+	// allocatedExtendedResourcesAnnotation lists the extended resources the scheduler added to
+	// the names of a claim when binding it, separated by comma
+	allocatedExtendedResourcesAnnotation = "extendedresource.scheduler/allocated"
+)
+
 // Bind delegates the action of binding a pod to a node.
-func Bind(clientset *kubernetes.Clientset) http.HandlerFunc {
+func Bind(extendedResourceScheduler *ExtendedResourceScheduler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		defer observeHandler("bind", time.Now())
 		var buf bytes.Buffer
 		body := io.TeeReader(r.Body, &buf)
 		var extenderBindingArgs schedulerapi.ExtenderBindingArgs
@@ -26,11 +41,9 @@ func Bind(clientset *kubernetes.Clientset) http.HandlerFunc {
 				Error: err.Error(),
 			}
 		} else {
-			extendedResourceScheduler := &ExtendedResourceScheduler{
-				Clientset: clientset,
-			}
 			extenderBindingResult = bind(extenderBindingArgs, extendedResourceScheduler)
 		}
+		recordBindResult(extenderBindingResult.Error)
 
 		w.Header().Set("Content-Type", "application/json")
 		if resultBody, err := json.Marshal(extenderBindingResult); err != nil {
@@ -48,6 +61,12 @@ func bind(extenderBindingArgs schedulerapi.ExtenderBindingArgs, extendedResource
 	podNamespace := extenderBindingArgs.PodNamespace
 
 	bindingResult := &schedulerapi.ExtenderBindingResult{}
+	// only the leader binds, kube-scheduler retries the pod when the bind fails
+	if err := extendedResourceScheduler.checkLeader(); err != nil {
+		glog.V(2).Infof("refuse to bind pod %s/%s: %v", podNamespace, podName, err)
+		bindingResult.Error = err.Error()
+		return bindingResult
+	}
 	pod, err := extendedResourceScheduler.FindPod(podName, podNamespace)
 	if err != nil {
 		glog.Errorf("find pod error, podname: %s podnamespace: %s", podName, podNamespace)
@@ -55,37 +74,43 @@ func bind(extenderBindingArgs schedulerapi.ExtenderBindingArgs, extendedResource
 		return bindingResult
 	}
 
+	defer func() {
+		if bindingResult.Error != "" {
+			extendedResourceScheduler.Recorder.Eventf(pod, v1.EventTypeWarning, reasonFailedBinding,
+				"bind to node %s failed: %s", extenderBindingArgs.Node, bindingResult.Error)
+		}
+	}()
+
 	extendedResourceClaims, err := extendedResourceScheduler.FindExtendedResourceClaimList(*pod)
 	if err != nil {
 		bindingResult.Error = err.Error()
 		return bindingResult
 	}
 
-	// TODO: update extendedresource and extendedresourceclaim asynchronously
-	for _, erc := range extendedResourceClaims {
-		erc.Status.Phase = v1alpha1.ExtendedResourceClaimBound
-		erc.Status.Reason = "ExtendedResourceClaim is already bound to ExtendedResource"
-		err := extendedResourceScheduler.UpdateExtendedResourceClaim(pod.Namespace, erc)
-		if err != nil {
-			bindingResult.Error = err.Error()
-			return bindingResult
-		}
-		extendedResources, err := extendedResourceScheduler.FindExtendedResourceList(erc.Spec.ExtendedResourceNames)
-		if err != nil {
-			bindingResult.Error = err.Error()
-			return bindingResult
+	nodeName := extenderBindingArgs.Node
+	reservations := extendedResourceScheduler.Reservations
+	plan, err := bindingPlan(pod, extendedResourceClaims, nodeName, extendedResourceScheduler)
+	if err == nil && reservations != nil {
+		// the node is chosen, reserve the plan on it until the extended resources are bound
+		err = reservations.Confirm(pod.UID, nodeName, plan)
+	}
+	if err != nil {
+		glog.Errorf("refuse to bind pod %s/%s to node %s: %v", podNamespace, podName, nodeName, err)
+		bindingResult.Error = fmt.Sprintf("refuse to bind pod %s/%s to node %s: %v", podNamespace, podName, nodeName, err)
+		if reservations != nil {
+			reservations.Release(pod.UID)
 		}
-		for _, er := range extendedResources {
-			er.Spec.ExtendedResourceClaimName = erc.Name
-			er.Status.Phase = v1alpha1.ExtendedResourceBound
-			err := extendedResourceScheduler.UpdateExtendedResource(er)
-			if err != nil {
-				er.Status.Phase = v1alpha1.ExtendedResourceAvailable
-				extendedResourceScheduler.UpdateExtendedResource(er)
-				bindingResult.Error = err.Error()
-				return bindingResult
-			}
+		return bindingResult
+	}
+	recordClaimsSatisfied(extendedResourceScheduler.Recorder, pod, plan, nodeName)
+
+	txn := newBindTransaction(extendedResourceScheduler)
+	if err := bindExtendedResources(txn, extendedResourceClaims, plan); err != nil {
+		bindingResult.Error = txn.abort(podNamespace, podName, nodeName, err).Error()
+		if reservations != nil {
+			reservations.Release(pod.UID)
 		}
+		return bindingResult
 	}
 
 	b := &v1.Binding{
@@ -96,13 +121,335 @@ func bind(extenderBindingArgs schedulerapi.ExtenderBindingArgs, extendedResource
 		},
 		Target: v1.ObjectReference{
 			Kind: "Node",
-			Name: extenderBindingArgs.Node,
+			Name: nodeName,
 		},
 	}
+	if err := extendedResourceScheduler.aborted(); err != nil {
+		bindingResult.Error = txn.abort(podNamespace, podName, nodeName, err).Error()
+		if reservations != nil {
+			reservations.Release(pod.UID)
+		}
+		return bindingResult
+	}
 	err = extendedResourceScheduler.Bind(podNamespace, b)
+	if err != nil && bindingSaved(extendedResourceScheduler, podNamespace, podName, extenderBindingArgs.PodUID, nodeName) {
+		// e.g. the request timed out after the apiserver saved the binding, the pod runs on the node
+		// with the extended resources, so they must not be rolled back
+		glog.Warningf("create binding of pod %s/%s failed: %v, but the pod is bound to node %s", podNamespace, podName, err, nodeName)
+		err = nil
+	}
 	if err != nil {
-		bindingResult.Error = err.Error()
+		bindingResult.Error = txn.abort(podNamespace, podName, nodeName, fmt.Errorf("create binding: %v", err)).Error()
+		if reservations != nil {
+			reservations.Release(pod.UID)
+		}
 		return bindingResult
 	}
+	txn.recordBound(pod, nodeName)
 	return bindingResult
 }
+
+// bindingSaved reads the pod from the storage and returns true if it is bound to the node,
+// i.e. the binding was saved even though creating it returned an error
+func bindingSaved(extendedResourceScheduler *ExtendedResourceScheduler, podNamespace, podName string, podUID types.UID, nodeName string) bool {
+	pod, err := extendedResourceScheduler.Storage.GetPod(podNamespace, podName)
+	if err != nil {
+		glog.Errorf("get pod %s/%s to check the binding: %v", podNamespace, podName, err)
+		return false
+	}
+	return pod.Spec.NodeName == nodeName && (podUID == "" || pod.UID == podUID)
+}
+
+// bindingPlan returns the allocation plan of the pod on the node chosen by kube-scheduler.
+// The plan computed by filter for that node is used if it is still kept, otherwise it is computed again.
+// Either way the plan is checked against the current state before it is returned.
+func bindingPlan(pod *v1.Pod, extendedResourceClaims []*v1alpha1.ExtendedResourceClaim, nodeName string, extendedResourceScheduler *ExtendedResourceScheduler) (allocationPlan, error) {
+	node, err := extendedResourceScheduler.FindNode(nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("find node: %v", err)
+	}
+
+	var plan allocationPlan
+	if reservations := extendedResourceScheduler.Reservations; reservations != nil {
+		plan, _ = reservations.Plan(pod.UID, nodeName)
+	}
+	if plan == nil {
+		glog.V(2).Infof("no allocation plan of pod %s/%s on node %s, computing it again", pod.Namespace, pod.Name, nodeName)
+		var reason *FailureReason
+		plan, reason = allocateExtendedResources(pod.UID, extendedResourceClaims, *node, extendedResourceScheduler)
+		if reason != nil {
+			return nil, fmt.Errorf("node can not satisfy the pod any more: %s", reason)
+		}
+	}
+
+	if err := validateAllocationPlan(pod.UID, plan, extendedResourceClaims, node, extendedResourceScheduler); err != nil {
+		return nil, fmt.Errorf("allocation plan is no longer valid: %v", err)
+	}
+	return plan, nil
+}
+
+// validateAllocationPlan checks that every claim is satisfied by plan, and that every selected extended resource
+// is still available, not reserved by another pod, allocatable on the node and allowed by its node affinity.
+func validateAllocationPlan(podUID types.UID, plan allocationPlan, extendedResourceClaims []*v1alpha1.ExtendedResourceClaim, node *v1.Node, extendedResourceScheduler *ExtendedResourceScheduler) error {
+	for _, erc := range extendedResourceClaims {
+		erNames, ok := plan[erc.Name]
+		if !ok {
+			return fmt.Errorf("extendedresourceclaim %s is not in the plan", erc.Name)
+		}
+		if erc.Spec.ExtendedResourceNum != 0 && int64(len(erNames)) < erc.Spec.ExtendedResourceNum {
+			return fmt.Errorf("extendedresourceclaim %s needs %d extended resources, but only %d are selected",
+				erc.Name, erc.Spec.ExtendedResourceNum, len(erNames))
+		}
+		for _, name := range erc.Spec.ExtendedResourceNames {
+			if !containsString(erNames, name) {
+				return fmt.Errorf("extended resource %s asked by extendedresourceclaim %s is not selected", name, erc.Name)
+			}
+		}
+		for _, name := range erNames {
+			if !containsString(node.Status.ExtendedResourceAllocatable, name) {
+				return fmt.Errorf("extended resource %s is not allocatable on node %s", name, node.Name)
+			}
+			er, err := extendedResourceScheduler.FindExtendedResource(name)
+			if err != nil {
+				return err
+			}
+			if er.Status.Phase != v1alpha1.ExtendedResourceAvailable {
+				return fmt.Errorf("extended resource %s is %s", name, er.Status.Phase)
+			}
+			// extended resources named by the claim are not selected by raw resource name, the same as filter
+			if !containsString(erc.Spec.ExtendedResourceNames, name) {
+				if er.Spec.RawResourceName != erc.Spec.RawResourceName {
+					return fmt.Errorf("extended resource %s is %s, but extendedresourceclaim %s asks for %s",
+						name, er.Spec.RawResourceName, erc.Name, erc.Spec.RawResourceName)
+				}
+				if !propertiesMatchRequirements(erc.Spec.MetadataRequirements, er.Spec.Properties) {
+					return fmt.Errorf("extended resource %s does not match the metadata requirements of extendedresourceclaim %s", name, erc.Name)
+				}
+			}
+			if !extendedResourceMatchesNode(er, node) {
+				return fmt.Errorf("node %s does not match the node affinity of extended resource %s", node.Name, name)
+			}
+			if reservedByOther(extendedResourceScheduler, name, podUID) {
+				return fmt.Errorf("extended resource %s is reserved by another pod", name)
+			}
+		}
+	}
+	return nil
+}
+
+// bindExtendedResources marks the claims and the extended resources selected for them by plan bound through txn.
+// An extended resource can only be bound if it is still available or already bound to the same claim.
+func bindExtendedResources(txn *bindTransaction, extendedResourceClaims []*v1alpha1.ExtendedResourceClaim, plan allocationPlan) error {
+	// TODO: update extendedresource and extendedresourceclaim asynchronously
+	for _, erc := range extendedResourceClaims {
+		erNames := plan[erc.Name]
+		err := txn.updateExtendedResourceClaim(erc, func(erc *v1alpha1.ExtendedResourceClaim) error {
+			return bindClaim(erc, erNames)
+		})
+		if err != nil {
+			return err
+		}
+		extendedResources, err := txn.extendedResourceScheduler.FindExtendedResourceList(erNames)
+		if err != nil {
+			return fmt.Errorf("find extendedresources of extendedresourceclaim %s/%s: %v", erc.Namespace, erc.Name, err)
+		}
+		for _, er := range extendedResources {
+			ercName := erc.Name
+			err := txn.updateExtendedResource(er, func(er *v1alpha1.ExtendedResource) error {
+				return bindExtendedResource(er, ercName)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bindClaim sets erNames as the extended resources of erc and marks it bound,
+// it fails if erc is already bound or lost with other extended resources
+func bindClaim(erc *v1alpha1.ExtendedResourceClaim, erNames []string) error {
+	if (erc.Status.Phase == v1alpha1.ExtendedResourceClaimBound || erc.Status.Phase == v1alpha1.ExtendedResourceClaimLost) &&
+		!sets.NewString(erc.Spec.ExtendedResourceNames...).Equal(sets.NewString(erNames...)) {
+		return fmt.Errorf("extendedresourceclaim %s/%s is already %s, extended resources: [%s]",
+			erc.Namespace, erc.Name, erc.Status.Phase, strings.Join(erc.Spec.ExtendedResourceNames, " "))
+	}
+	// remember the extended resources selected by the scheduler, they are removed again on release
+	allocated := make([]string, 0)
+	for _, name := range erNames {
+		if !containsString(erc.Spec.ExtendedResourceNames, name) {
+			allocated = append(allocated, name)
+		}
+	}
+	if len(allocated) > 0 {
+		if erc.Annotations == nil {
+			erc.Annotations = make(map[string]string)
+		}
+		erc.Annotations[allocatedExtendedResourcesAnnotation] = strings.Join(allocated, ",")
+	}
+	erc.Spec.ExtendedResourceNames = erNames
+	erc.Status.Phase = v1alpha1.ExtendedResourceClaimBound
+	erc.Status.Reason = claimBoundReason
+	return nil
+}
+
+// bindExtendedResource marks er bound to the claim ercName,
+// it fails unless er is still available or already bound to the same claim
+func bindExtendedResource(er *v1alpha1.ExtendedResource, ercName string) error {
+	if er.Status.Phase != v1alpha1.ExtendedResourceAvailable &&
+		!(er.Status.Phase == v1alpha1.ExtendedResourceBound && er.Spec.ExtendedResourceClaimName == ercName) {
+		return fmt.Errorf("extendedresource %s is no longer available, phase: %s, claim: %q",
+			er.Name, er.Status.Phase, er.Spec.ExtendedResourceClaimName)
+	}
+	er.Spec.ExtendedResourceClaimName = ercName
+	er.Status.Phase = v1alpha1.ExtendedResourceBound
+	return nil
+}
+
+// bindTransaction applies the changes of extendedresources and extendedresourceclaims during bind
+// and records their prior state, so that every touched object can be restored if any step fails.
+type bindTransaction struct {
+	extendedResourceScheduler *ExtendedResourceScheduler
+	// extendedResources and extendedResourceClaims hold the latest object written by the transaction
+	// and the object before it was changed, in the order they were changed
+	extendedResources      []extendedResourceChange
+	extendedResourceClaims []extendedResourceClaimChange
+}
+
+type extendedResourceChange struct {
+	current, prior *v1alpha1.ExtendedResource
+}
+
+type extendedResourceClaimChange struct {
+	current, prior *v1alpha1.ExtendedResourceClaim
+}
+
+func newBindTransaction(extendedResourceScheduler *ExtendedResourceScheduler) *bindTransaction {
+	return &bindTransaction{
+		extendedResourceScheduler: extendedResourceScheduler,
+	}
+}
+
+// updateExtendedResourceClaim applies mutate to erc and writes it
+func (t *bindTransaction) updateExtendedResourceClaim(erc *v1alpha1.ExtendedResourceClaim, mutate func(*v1alpha1.ExtendedResourceClaim) error) error {
+	if err := t.extendedResourceScheduler.aborted(); err != nil {
+		return err
+	}
+	var prior *v1alpha1.ExtendedResourceClaim
+	current, err := t.extendedResourceScheduler.UpdateExtendedResourceClaim(erc, func(erc *v1alpha1.ExtendedResourceClaim) error {
+		prior = erc.DeepCopy()
+		return mutate(erc)
+	})
+	if err != nil {
+		return fmt.Errorf("update extendedresourceclaim %s/%s: %v", erc.Namespace, erc.Name, err)
+	}
+	t.extendedResourceClaims = append(t.extendedResourceClaims, extendedResourceClaimChange{current: current, prior: prior})
+	return nil
+}
+
+// updateExtendedResource applies mutate to er and writes it
+func (t *bindTransaction) updateExtendedResource(er *v1alpha1.ExtendedResource, mutate func(*v1alpha1.ExtendedResource) error) error {
+	if err := t.extendedResourceScheduler.aborted(); err != nil {
+		return err
+	}
+	var prior *v1alpha1.ExtendedResource
+	current, err := t.extendedResourceScheduler.UpdateExtendedResource(er, func(er *v1alpha1.ExtendedResource) error {
+		prior = er.DeepCopy()
+		return mutate(er)
+	})
+	if err != nil {
+		return fmt.Errorf("update extendedresource %s: %v", er.Name, err)
+	}
+	t.extendedResources = append(t.extendedResources, extendedResourceChange{current: current, prior: prior})
+	return nil
+}
+
+// rollback restores every changed extendedresource and extendedresourceclaim to its prior phase and claim,
+// in the reverse order of the changes. Objects changed by others since are left as they are.
+// It tries all objects and returns the aggregated errors.
+func (t *bindTransaction) rollback() error {
+	errs := make([]error, 0)
+	for i := len(t.extendedResources) - 1; i >= 0; i-- {
+		change := t.extendedResources[i]
+		_, err := t.extendedResourceScheduler.UpdateExtendedResource(change.current, func(er *v1alpha1.ExtendedResource) error {
+			if er.Status.Phase != change.current.Status.Phase ||
+				er.Spec.ExtendedResourceClaimName != change.current.Spec.ExtendedResourceClaimName {
+				return fmt.Errorf("changed by others, phase: %s, claim: %q", er.Status.Phase, er.Spec.ExtendedResourceClaimName)
+			}
+			er.Spec.ExtendedResourceClaimName = change.prior.Spec.ExtendedResourceClaimName
+			er.Status = change.prior.Status
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restore extendedresource %s: %v", change.current.Name, err))
+		}
+	}
+	for i := len(t.extendedResourceClaims) - 1; i >= 0; i-- {
+		change := t.extendedResourceClaims[i]
+		_, err := t.extendedResourceScheduler.UpdateExtendedResourceClaim(change.current, func(erc *v1alpha1.ExtendedResourceClaim) error {
+			if erc.Status.Phase != change.current.Status.Phase {
+				return fmt.Errorf("changed by others, phase: %s", erc.Status.Phase)
+			}
+			erc.Annotations = change.prior.Annotations
+			erc.Spec.ExtendedResourceNames = change.prior.Spec.ExtendedResourceNames
+			erc.Status = change.prior.Status
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restore extendedresourceclaim %s/%s: %v", change.current.Namespace, change.current.Name, err))
+		}
+	}
+	t.extendedResources = nil
+	t.extendedResourceClaims = nil
+	return utilerrors.NewAggregate(errs)
+}
+
+// recordClaimsSatisfied records an event about the pod whose claims are satisfied by the plan on the node
+func recordClaimsSatisfied(recorder *EventRecorder, pod *v1.Pod, plan allocationPlan, nodeName string) {
+	if recorder == nil {
+		return
+	}
+	ercNames := make([]string, 0, len(plan))
+	for ercName := range plan {
+		ercNames = append(ercNames, ercName)
+	}
+	sort.Strings(ercNames)
+	allocations := make([]string, 0, len(ercNames))
+	for _, ercName := range ercNames {
+		allocations = append(allocations, fmt.Sprintf("%s=[%s]", ercName, strings.Join(plan[ercName], " ")))
+	}
+	recorder.Eventf(pod, v1.EventTypeNormal, reasonClaimsSatisfied, "extendedresourceclaims are satisfied on node %s by %s",
+		nodeName, strings.Join(allocations, " "))
+}
+
+// recordBound records events about the pod, the claims and the extended resources bound by the transaction
+func (t *bindTransaction) recordBound(pod *v1.Pod, nodeName string) {
+	recorder := t.extendedResourceScheduler.Recorder
+	if recorder == nil {
+		return
+	}
+	claimNames := make([]string, 0, len(t.extendedResourceClaims))
+	for _, change := range t.extendedResourceClaims {
+		erc := change.current
+		claimNames = append(claimNames, erc.Name)
+		recorder.Eventf(erc, v1.EventTypeNormal, reasonClaimBound, "bound to extended resources [%s] for pod %s on node %s",
+			strings.Join(erc.Spec.ExtendedResourceNames, " "), pod.Name, nodeName)
+	}
+	for _, change := range t.extendedResources {
+		er := change.current
+		recorder.Eventf(er, v1.EventTypeNormal, reasonExtendedResourceBound, "bound to extendedresourceclaim %s/%s of pod %s on node %s",
+			pod.Namespace, er.Spec.ExtendedResourceClaimName, pod.Name, nodeName)
+	}
+	recorder.Eventf(pod, v1.EventTypeNormal, reasonClaimBound, "extendedresourceclaims [%s] are bound on node %s",
+		strings.Join(claimNames, " "), nodeName)
+}
+
+// abort rolls back the transaction and returns the error describing both the cause and the rollback result
+func (t *bindTransaction) abort(podNamespace, podName, nodeName string, cause error) error {
+	glog.Errorf("bind pod %s/%s to node %s failed: %v, rolling back", podNamespace, podName, nodeName, cause)
+	if err := t.rollback(); err != nil {
+		glog.Errorf("rollback bind of pod %s/%s failed: %v", podNamespace, podName, err)
+		return fmt.Errorf("bind pod %s/%s to node %s failed: %v; rollback failed: %v", podNamespace, podName, nodeName, cause, err)
+	}
+	return fmt.Errorf("bind pod %s/%s to node %s failed: %v; all changes were rolled back", podNamespace, podName, nodeName, cause)
+}
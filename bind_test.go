@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+func TestFilterAndBind(t *testing.T) {
+	k80 := map[string]string{"model": "k80"}
+	storage := NewMemoryStorage()
+	err := storage.Add(
+		newTestER("er1", k80), newTestER("er2", k80),
+		newTestNode("node1", "er1"), newTestNode("node2", "er2"),
+		newTestClaim("erc1", 1, k80), newTestClaim("erc2", 1, k80),
+		newTestPod("pod1", "erc1"), newTestPod("pod2", "erc2"),
+	)
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	extendedResourceScheduler := &ExtendedResourceScheduler{Storage: storage, Reservations: NewReservationCache(time.Minute)}
+	filterPod := func(pod *v1.Pod) *schedulerapi.ExtenderFilterResult {
+		return filter(schedulerapi.ExtenderArgs{Pod: *pod, NodeNames: &[]string{"node1", "node2"}}, extendedResourceScheduler)
+	}
+	bindPod := func(pod *v1.Pod, nodeName string) *schedulerapi.ExtenderBindingResult {
+		return bind(schedulerapi.ExtenderBindingArgs{PodName: pod.Name, PodNamespace: pod.Namespace, PodUID: pod.UID, Node: nodeName}, extendedResourceScheduler)
+	}
+
+	// pod1 fits on both nodes, kube-scheduler chooses node2
+	pod1 := newTestPod("pod1", "erc1")
+	if result := filterPod(pod1); result.Error != "" || !reflect.DeepEqual(*result.NodeNames, []string{"node1", "node2"}) {
+		t.Fatalf("expected pod1 to fit on node1 and node2, got %+v", result)
+	}
+	if result := bindPod(pod1, "node2"); result.Error != "" {
+		t.Fatalf("bind pod1 failed: %s", result.Error)
+	}
+	if pod, _ := storage.GetPod("default", "pod1"); pod.Spec.NodeName != "node2" {
+		t.Errorf("expected pod1 on node2, got %q", pod.Spec.NodeName)
+	}
+	if erc, _ := storage.GetExtendedResourceClaim("default", "erc1"); erc.Status.Phase != v1alpha1.ExtendedResourceClaimBound ||
+		!reflect.DeepEqual(erc.Spec.ExtendedResourceNames, []string{"er2"}) {
+		t.Errorf("expected erc1 bound to er2, got %+v", erc)
+	}
+	if er, _ := storage.GetExtendedResource("er2"); er.Status.Phase != v1alpha1.ExtendedResourceBound || er.Spec.ExtendedResourceClaimName != "erc1" {
+		t.Errorf("expected er2 bound to erc1, got %+v", er)
+	}
+
+	// er2 is taken, so pod2 only fits on node1
+	pod2 := newTestPod("pod2", "erc2")
+	result := filterPod(pod2)
+	if result.Error != "" || !reflect.DeepEqual(*result.NodeNames, []string{"node1"}) {
+		t.Fatalf("expected pod2 to fit on node1 only, got %+v", result)
+	}
+	if result.FailedNodes["node2"] != "er2 bound" {
+		t.Errorf("expected node2 to fail because er2 is bound, got %q", result.FailedNodes["node2"])
+	}
+
+	// pod2 gets a node elsewhere before the binding is created, the claim and er1 are restored
+	err = storage.Bind("default", &v1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod2"},
+		Target:     v1.ObjectReference{Kind: "Node", Name: "node3"},
+	})
+	if err != nil {
+		t.Fatalf("bind pod2 to node3 failed: %v", err)
+	}
+	if result := bindPod(pod2, "node1"); !strings.Contains(result.Error, "all changes were rolled back") {
+		t.Errorf("expected the bind of pod2 to be rolled back, got %q", result.Error)
+	}
+	if erc, _ := storage.GetExtendedResourceClaim("default", "erc2"); erc.Status.Phase != "" || len(erc.Spec.ExtendedResourceNames) != 0 {
+		t.Errorf("expected erc2 restored, got %+v", erc)
+	}
+	if er, _ := storage.GetExtendedResource("er1"); er.Status.Phase != v1alpha1.ExtendedResourceAvailable || er.Spec.ExtendedResourceClaimName != "" {
+		t.Errorf("expected er1 restored, got %+v", er)
+	}
+}
+
+func TestBindClaim(t *testing.T) {
+	tests := []struct {
+		name    string
+		phase   v1alpha1.ExtendedResourceClaimPhase
+		erNames []string
+		err     bool
+	}{
+		{name: "pending claim", phase: v1alpha1.ExtendedResourceClaimPending},
+		{name: "bound to the same extended resources", phase: v1alpha1.ExtendedResourceClaimBound, erNames: []string{"er2", "er1"}},
+		{name: "bound to other extended resources", phase: v1alpha1.ExtendedResourceClaimBound, erNames: []string{"er3"}, err: true},
+		{name: "lost with other extended resources", phase: v1alpha1.ExtendedResourceClaimLost, erNames: []string{"er3"}, err: true},
+	}
+	for _, test := range tests {
+		erc := newTestClaim("erc1", 2, nil)
+		erc.Spec.ExtendedResourceNames = test.erNames
+		erc.Status.Phase = test.phase
+		err := bindClaim(erc, []string{"er1", "er2"})
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if err == nil && (erc.Status.Phase != v1alpha1.ExtendedResourceClaimBound || !reflect.DeepEqual(erc.Spec.ExtendedResourceNames, []string{"er1", "er2"})) {
+			t.Errorf("%s: expected the claim bound to er1 and er2, got %+v", test.name, erc)
+		}
+	}
+}
+
+func TestBindExtendedResourcesClaimBoundByOthers(t *testing.T) {
+	storage := NewMemoryStorage()
+	if err := storage.Add(newTestER("er1", nil), newTestClaim("erc1", 1, nil)); err != nil {
+		t.Fatal(err)
+	}
+	extendedResourceScheduler := &ExtendedResourceScheduler{Storage: storage}
+	stale, _ := storage.GetExtendedResourceClaim("default", "erc1")
+
+	// another writer binds erc1 to er2 after the claim is read
+	latest := stale.DeepCopy()
+	latest.Spec.ExtendedResourceNames = []string{"er2"}
+	latest.Status.Phase = v1alpha1.ExtendedResourceClaimBound
+	if _, err := storage.UpdateExtendedResourceClaim(latest); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := newBindTransaction(extendedResourceScheduler)
+	err := bindExtendedResources(txn, []*v1alpha1.ExtendedResourceClaim{stale}, allocationPlan{"erc1": {"er1"}})
+	if err == nil || !strings.Contains(err.Error(), "is already Bound") {
+		t.Fatalf("expected the claim bound by others to be refused, got %v", err)
+	}
+	if erc, _ := storage.GetExtendedResourceClaim("default", "erc1"); !reflect.DeepEqual(erc.Spec.ExtendedResourceNames, []string{"er2"}) {
+		t.Errorf("expected erc1 to keep er2, got %v", erc.Spec.ExtendedResourceNames)
+	}
+	if er, _ := storage.GetExtendedResource("er1"); er.Status.Phase != v1alpha1.ExtendedResourceAvailable {
+		t.Errorf("expected er1 to stay available, got %s", er.Status.Phase)
+	}
+}
+
+func TestBindingPlan(t *testing.T) {
+	otherNodes := &v1alpha1.ResourceNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+		MatchExpressions: []v1.NodeSelectorRequirement{{Key: "gpu", Operator: v1.NodeSelectorOpIn, Values: []string{"p100"}}},
+	}}}}
+	affinityMismatch := newTestER("er1", nil)
+	affinityMismatch.Spec.NodeAffinity = otherNodes
+	tests := []struct {
+		name       string
+		storedPlan allocationPlan
+		er1        *v1alpha1.ExtendedResource
+		node       *v1.Node
+		expected   allocationPlan
+		err        string
+	}{
+		{
+			name:       "stored plan still valid",
+			storedPlan: allocationPlan{"erc1": {"er1"}},
+			er1:        newTestER("er1", nil),
+			node:       newTestNode("node1", "er1", "er2"),
+			expected:   allocationPlan{"erc1": {"er1"}},
+		},
+		{
+			name:     "no stored plan for the node, computed again",
+			er1:      withPhase(newTestER("er1", nil), v1alpha1.ExtendedResourceBound),
+			node:     newTestNode("node1", "er1", "er2"),
+			expected: allocationPlan{"erc1": {"er2"}},
+		},
+		{
+			name:       "extended resource of the stored plan bound since filter",
+			storedPlan: allocationPlan{"erc1": {"er1"}},
+			er1:        boundTo(newTestER("er1", nil), "erc2"),
+			node:       newTestNode("node1", "er1", "er2"),
+			err:        "extended resource er1 is Bound",
+		},
+		{
+			name:       "extended resource of the stored plan no longer matches the node affinity",
+			storedPlan: allocationPlan{"erc1": {"er1"}},
+			er1:        affinityMismatch,
+			node:       newTestNode("node1", "er1", "er2"),
+			err:        "node node1 does not match the node affinity of extended resource er1",
+		},
+		{
+			name:       "extended resource of the stored plan no longer allocatable on the node",
+			storedPlan: allocationPlan{"erc1": {"er1"}},
+			er1:        newTestER("er1", nil),
+			node:       newTestNode("node1", "er2"),
+			err:        "extended resource er1 is not allocatable on node node1",
+		},
+	}
+	for _, test := range tests {
+		pod := newTestPod("pod1", "erc1")
+		erc := newTestClaim("erc1", 1, nil)
+		extendedResourceScheduler := &ExtendedResourceScheduler{
+			Cache:        newTestCache(test.er1, newTestER("er2", nil), test.node, erc, pod),
+			Reservations: NewReservationCache(time.Minute),
+		}
+		if test.storedPlan != nil {
+			extendedResourceScheduler.Reservations.AddPlan(pod.UID, "node1", test.storedPlan)
+		}
+		plan, err := bindingPlan(pod, []*v1alpha1.ExtendedResourceClaim{erc}, "node1", extendedResourceScheduler)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(plan, test.expected) {
+			t.Errorf("%s: expected plan %v, got %v", test.name, test.expected, plan)
+		}
+	}
+}
+
+// failingBindStorage fails every binding, after bind has written the claims and extended resources
+type failingBindStorage struct {
+	Storage
+}
+
+func (s failingBindStorage) Bind(namespace string, binding *v1.Binding) error {
+	return errors.New("apiserver unavailable")
+}
+
+func TestBindRollback(t *testing.T) {
+	k80 := map[string]string{"model": "k80"}
+	named := newTestClaim("named", 2, k80)
+	named.Spec.ExtendedResourceNames = []string{"er1"}
+	objects := []runtime.Object{
+		newTestER("er1", k80), newTestER("er2", k80), newTestER("er3", k80),
+		newTestNode("node1", "er1", "er2", "er3"),
+		named, newTestClaim("any", 1, k80),
+		newTestPod("pod1", "named", "any"),
+	}
+	memory := NewMemoryStorage()
+	if err := memory.Add(objects...); err != nil {
+		t.Fatal(err)
+	}
+	extendedResourceScheduler := &ExtendedResourceScheduler{Storage: failingBindStorage{memory}}
+	versions := make(map[string]string)
+	for _, obj := range objects {
+		if er, ok := obj.(*v1alpha1.ExtendedResource); ok {
+			stored, _ := memory.GetExtendedResource(er.Name)
+			versions[er.Name] = stored.ResourceVersion
+		}
+	}
+
+	result := bind(schedulerapi.ExtenderBindingArgs{PodName: "pod1", PodNamespace: "default", PodUID: "pod1", Node: "node1"}, extendedResourceScheduler)
+	if !strings.Contains(result.Error, "apiserver unavailable") || !strings.Contains(result.Error, "all changes were rolled back") {
+		t.Fatalf("expected the bind to fail and be rolled back, got %q", result.Error)
+	}
+
+	// every claim and extended resource is as it was, except for its resourceVersion
+	for _, obj := range objects {
+		var current runtime.Object
+		switch obj := obj.(type) {
+		case *v1alpha1.ExtendedResource:
+			er, _ := memory.GetExtendedResource(obj.Name)
+			if er.ResourceVersion == versions[er.Name] {
+				t.Errorf("expected %s to be written by bind", obj.Name)
+			}
+			er.ResourceVersion = ""
+			current = er
+		case *v1alpha1.ExtendedResourceClaim:
+			erc, _ := memory.GetExtendedResourceClaim(obj.Namespace, obj.Name)
+			erc.ResourceVersion = ""
+			current = erc
+		default:
+			continue
+		}
+		if !reflect.DeepEqual(current, obj) {
+			t.Errorf("expected %+v to be restored, got %+v", obj, current)
+		}
+	}
+}
+
+// timeoutBindStorage saves every binding but returns an error, as a request timing out after the apiserver saved it
+type timeoutBindStorage struct {
+	Storage
+}
+
+func (s timeoutBindStorage) Bind(namespace string, binding *v1.Binding) error {
+	if err := s.Storage.Bind(namespace, binding); err != nil {
+		return err
+	}
+	return errors.New("the server was unable to return a response in the time allotted")
+}
+
+func TestBindSavedDespiteError(t *testing.T) {
+	memory := NewMemoryStorage()
+	err := memory.Add(newTestER("er1", nil), newTestNode("node1", "er1"), newTestClaim("erc1", 1, nil), newTestPod("pod1", "erc1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	extendedResourceScheduler := &ExtendedResourceScheduler{Storage: timeoutBindStorage{memory}}
+
+	result := bind(schedulerapi.ExtenderBindingArgs{PodName: "pod1", PodNamespace: "default", PodUID: "pod1", Node: "node1"}, extendedResourceScheduler)
+	if result.Error != "" {
+		t.Fatalf("expected the bind to succeed since the binding was saved, got %q", result.Error)
+	}
+	if er, _ := memory.GetExtendedResource("er1"); er.Status.Phase != v1alpha1.ExtendedResourceBound || er.Spec.ExtendedResourceClaimName != "erc1" {
+		t.Errorf("expected er1 to stay bound to erc1, got %+v", er)
+	}
+	if erc, _ := memory.GetExtendedResourceClaim("default", "erc1"); erc.Status.Phase != v1alpha1.ExtendedResourceClaimBound {
+		t.Errorf("expected erc1 to stay bound, got %s", erc.Status.Phase)
+	}
+}
+
+func TestBindRecordsClaimsSatisfied(t *testing.T) {
+	memory := NewMemoryStorage()
+	err := memory.Add(newTestER("er1", nil), newTestER("er2", nil), newTestNode("node1", "er1", "er2"),
+		newTestClaim("erc1", 1, nil), newTestClaim("erc2", 1, nil), newTestPod("pod1", "erc1", "erc2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := &fakeEvents{items: make(map[string]*v1.Event)}
+	recorder := NewEventRecorder(events, "host")
+	extendedResourceScheduler := &ExtendedResourceScheduler{Storage: memory, Recorder: recorder}
+
+	result := bind(schedulerapi.ExtenderBindingArgs{PodName: "pod1", PodNamespace: "default", PodUID: "pod1", Node: "node1"}, extendedResourceScheduler)
+	if result.Error != "" {
+		t.Fatalf("expected the bind to succeed, got %q", result.Error)
+	}
+	for len(recorder.queue) > 0 {
+		recorder.write(<-recorder.queue)
+	}
+	// the claims satisfied by the plan are reported on the pod besides the bound ones
+	reasons := make(map[string]string)
+	for _, event := range events.items {
+		if event.InvolvedObject.Kind == "Pod" {
+			reasons[event.Reason] = event.Message
+		}
+	}
+	// which claim gets which of the equal extended resources is up to the allocation
+	prefix := "extendedresourceclaims are satisfied on node node1 by erc1=["
+	if message := reasons[reasonClaimsSatisfied]; !strings.HasPrefix(message, prefix) || !strings.Contains(message, "] erc2=[") {
+		t.Errorf("expected a %s event on the pod starting with %q, got %v", reasonClaimsSatisfied, prefix, reasons)
+	}
+	if _, ok := reasons[reasonClaimBound]; !ok {
+		t.Errorf("expected a %s event on the pod, got %v", reasonClaimBound, reasons)
+	}
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// index names of the cache
+	indexByPhase            = "phase"
+	indexByClaim            = "claim"
+	indexByExtendedResource = "extendedResource"
+
+	// watchTimeout is the max duration of a single watch, the watch is restarted after it
+	watchTimeout = 5 * time.Minute
+	// relistPeriod is the wait time before list again after the watch failed
+	relistPeriod = time.Second
+)
+
+// ResourceCache keeps a local copy of ExtendedResources, ExtendedResourceClaims, Pods and Nodes.
+// It lists all objects once and then keeps them up to date by watching the api server.
+type ResourceCache struct {
+	extendedResources      *reflector
+	extendedResourceClaims *reflector
+	pods                   *reflector
+	nodes                  *reflector
+}
+
+// NewResourceCache creates a ResourceCache, Run must be called before using it
+func NewResourceCache(clientset *kubernetes.Clientset) *ResourceCache {
+	erClient := clientset.ExtensionsV1alpha1().ExtendedResources()
+	ercClient := clientset.ExtensionsV1alpha1().ExtendedResourceClaims(metav1.NamespaceAll)
+	podClient := clientset.CoreV1().Pods(metav1.NamespaceAll)
+	nodeClient := clientset.CoreV1().Nodes()
+
+	return &ResourceCache{
+		extendedResources: newReflector("extendedresource",
+			func() ([]runtime.Object, string, error) {
+				defer observeAPIRequest("list", "extendedresources", time.Now())
+				list, err := erClient.List(metav1.ListOptions{})
+				if err != nil {
+					return nil, "", err
+				}
+				objs := make([]runtime.Object, 0, len(list.Items))
+				for i := range list.Items {
+					objs = append(objs, &list.Items[i])
+				}
+				return objs, list.ResourceVersion, nil
+			},
+			observeWatch("extendedresources", erClient.Watch),
+			// extended resources are looked up by the names allocatable on a node, see ListExtendedResourcesByNode
+			nil),
+		extendedResourceClaims: newReflector("extendedresourceclaim",
+			func() ([]runtime.Object, string, error) {
+				defer observeAPIRequest("list", "extendedresourceclaims", time.Now())
+				list, err := ercClient.List(metav1.ListOptions{})
+				if err != nil {
+					return nil, "", err
+				}
+				objs := make([]runtime.Object, 0, len(list.Items))
+				for i := range list.Items {
+					objs = append(objs, &list.Items[i])
+				}
+				return objs, list.ResourceVersion, nil
+			},
+			observeWatch("extendedresourceclaims", ercClient.Watch),
+			map[string]indexFunc{
+				indexByPhase: func(obj runtime.Object) []string {
+					return []string{string(obj.(*v1alpha1.ExtendedResourceClaim).Status.Phase)}
+				},
+			}),
+		pods: newReflector("pod",
+			func() ([]runtime.Object, string, error) {
+				defer observeAPIRequest("list", "pods", time.Now())
+				list, err := podClient.List(metav1.ListOptions{})
+				if err != nil {
+					return nil, "", err
+				}
+				objs := make([]runtime.Object, 0, len(list.Items))
+				for i := range list.Items {
+					objs = append(objs, &list.Items[i])
+				}
+				return objs, list.ResourceVersion, nil
+			},
+			observeWatch("pods", podClient.Watch),
+			map[string]indexFunc{
+				indexByClaim: func(obj runtime.Object) []string {
+					pod := obj.(*v1.Pod)
+					keys := make([]string, 0)
+					for _, container := range pod.Spec.Containers {
+						for _, ercName := range container.ExtendedResourceClaims {
+							keys = append(keys, pod.Namespace+"/"+ercName)
+						}
+					}
+					return keys
+				},
+			}),
+		nodes: newReflector("node",
+			func() ([]runtime.Object, string, error) {
+				defer observeAPIRequest("list", "nodes", time.Now())
+				list, err := nodeClient.List(metav1.ListOptions{})
+				if err != nil {
+					return nil, "", err
+				}
+				objs := make([]runtime.Object, 0, len(list.Items))
+				for i := range list.Items {
+					objs = append(objs, &list.Items[i])
+				}
+				return objs, list.ResourceVersion, nil
+			},
+			observeWatch("nodes", nodeClient.Watch),
+			map[string]indexFunc{
+				indexByExtendedResource: func(obj runtime.Object) []string {
+					return obj.(*v1.Node).Status.ExtendedResourceAllocatable
+				},
+			}),
+	}
+}
+
+// ResourceEventHandler is called when a cached object is added, modified or deleted.
+// Objects listed again after a watch failure are delivered as added,
+// and those missing from the list, i.e. deleted while the watch was down, as deleted.
+type ResourceEventHandler func(eventType watch.EventType, obj runtime.Object)
+
+// AddEventHandler registers handler for the changes of all cached resources,
+// handlers must not block since they are called from the watch loops
+func (c *ResourceCache) AddEventHandler(handler ResourceEventHandler) {
+	for _, r := range c.reflectors() {
+		r.addEventHandler(handler)
+	}
+}
+
+// Run starts watching all resources until stopCh is closed
+func (c *ResourceCache) Run(stopCh <-chan struct{}) {
+	for _, r := range c.reflectors() {
+		go r.run(stopCh)
+	}
+}
+
+// HasSynced returns true after all resources have been listed once
+func (c *ResourceCache) HasSynced() bool {
+	for _, r := range c.reflectors() {
+		if !r.hasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// WaitForCacheSync waits until all resources have been listed once, it returns false if stopCh is closed before that
+func (c *ResourceCache) WaitForCacheSync(stopCh <-chan struct{}) bool {
+	err := wait.PollUntil(100*time.Millisecond, func() (bool, error) {
+		return c.HasSynced(), nil
+	}, stopCh)
+	return err == nil
+}
+
+func (c *ResourceCache) reflectors() []*reflector {
+	return []*reflector{c.extendedResources, c.extendedResourceClaims, c.pods, c.nodes}
+}
+
+// GetExtendedResource returns a copy of the cached extendedresource
+func (c *ResourceCache) GetExtendedResource(name string) (*v1alpha1.ExtendedResource, error) {
+	obj, ok := c.extendedResources.store.get(name)
+	if !ok {
+		return nil, newNotFoundError("extendedresource", name)
+	}
+	return obj.(*v1alpha1.ExtendedResource), nil
+}
+
+// ListExtendedResourcesByNode returns copies of the cached extendedresources allocatable on the node,
+// those missing from the cache are left out
+func (c *ResourceCache) ListExtendedResourcesByNode(nodeName string) []*v1alpha1.ExtendedResource {
+	node, err := c.GetNode(nodeName)
+	if err != nil {
+		return nil
+	}
+	extendedResources, _ := c.GetExtendedResources(node.Status.ExtendedResourceAllocatable)
+	return extendedResources
+}
+
+// GetExtendedResources returns copies of the cached extendedresources named by erNames, e.g. those allocatable
+// on a node, read at once. It returns the names missing from the cache too.
+func (c *ResourceCache) GetExtendedResources(erNames []string) ([]*v1alpha1.ExtendedResource, []string) {
+	objs, missing := c.extendedResources.store.getAll(erNames)
+	extendedResources := make([]*v1alpha1.ExtendedResource, 0, len(objs))
+	for _, obj := range objs {
+		extendedResources = append(extendedResources, obj.(*v1alpha1.ExtendedResource))
+	}
+	return extendedResources, missing
+}
+
+// GetExtendedResourceClaim returns a copy of the cached extendedresourceclaim
+func (c *ResourceCache) GetExtendedResourceClaim(namespace, name string) (*v1alpha1.ExtendedResourceClaim, error) {
+	obj, ok := c.extendedResourceClaims.store.get(namespace + "/" + name)
+	if !ok {
+		return nil, newNotFoundError("extendedresourceclaim", namespace+"/"+name)
+	}
+	return obj.(*v1alpha1.ExtendedResourceClaim), nil
+}
+
+// ListExtendedResourceClaimsByPhase returns copies of all extendedresourceclaims in the phase
+func (c *ResourceCache) ListExtendedResourceClaimsByPhase(phase v1alpha1.ExtendedResourceClaimPhase) []*v1alpha1.ExtendedResourceClaim {
+	objs := c.extendedResourceClaims.store.byIndex(indexByPhase, string(phase))
+	extendedResourceClaims := make([]*v1alpha1.ExtendedResourceClaim, 0, len(objs))
+	for _, obj := range objs {
+		extendedResourceClaims = append(extendedResourceClaims, obj.(*v1alpha1.ExtendedResourceClaim))
+	}
+	return extendedResourceClaims
+}
+
+// GetPod returns a copy of the cached pod
+func (c *ResourceCache) GetPod(namespace, name string) (*v1.Pod, error) {
+	obj, ok := c.pods.store.get(namespace + "/" + name)
+	if !ok {
+		return nil, newNotFoundError("pod", namespace+"/"+name)
+	}
+	return obj.(*v1.Pod), nil
+}
+
+// ListPodsByClaim returns copies of all pods which use the extendedresourceclaim
+func (c *ResourceCache) ListPodsByClaim(namespace, ercName string) []*v1.Pod {
+	return toPods(c.pods.store.byIndex(indexByClaim, namespace+"/"+ercName))
+}
+
+// GetNode returns a copy of the cached node
+func (c *ResourceCache) GetNode(name string) (*v1.Node, error) {
+	obj, ok := c.nodes.store.get(name)
+	if !ok {
+		return nil, newNotFoundError("node", name)
+	}
+	return obj.(*v1.Node), nil
+}
+
+// ListNodes returns copies of all nodes
+func (c *ResourceCache) ListNodes() []*v1.Node {
+	objs := c.nodes.store.list()
+	nodes := make([]*v1.Node, 0, len(objs))
+	for _, obj := range objs {
+		nodes = append(nodes, obj.(*v1.Node))
+	}
+	return nodes
+}
+
+// ListNodesByExtendedResource returns copies of all nodes on which the extendedresource is allocatable
+func (c *ResourceCache) ListNodesByExtendedResource(erName string) []*v1.Node {
+	objs := c.nodes.store.byIndex(indexByExtendedResource, erName)
+	nodes := make([]*v1.Node, 0, len(objs))
+	for _, obj := range objs {
+		nodes = append(nodes, obj.(*v1.Node))
+	}
+	return nodes
+}
+
+func toPods(objs []runtime.Object) []*v1.Pod {
+	pods := make([]*v1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		pods = append(pods, obj.(*v1.Pod))
+	}
+	return pods
+}
+
+func newNotFoundError(kind, key string) error {
+	return fmt.Errorf("%s %q not found in cache", kind, key)
+}
+
+// indexFunc calculates the index values of an object
+type indexFunc func(obj runtime.Object) []string
+
+// indexedStore is a thread safe object store with indices.
+// Objects are keyed by namespace/name, or name if they are not namespaced.
+type indexedStore struct {
+	lock     sync.RWMutex
+	items    map[string]runtime.Object
+	indexers map[string]indexFunc
+	// indices maps index name to index value to object keys
+	indices map[string]map[string]sets.String
+}
+
+func newIndexedStore(indexers map[string]indexFunc) *indexedStore {
+	indices := make(map[string]map[string]sets.String)
+	for name := range indexers {
+		indices[name] = make(map[string]sets.String)
+	}
+	return &indexedStore{
+		items:    make(map[string]runtime.Object),
+		indexers: indexers,
+		indices:  indices,
+	}
+}
+
+func objectKey(obj runtime.Object) (string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	if accessor.GetNamespace() == "" {
+		return accessor.GetName(), nil
+	}
+	return accessor.GetNamespace() + "/" + accessor.GetName(), nil
+}
+
+// get returns a deep copy of the object, so callers are free to modify it
+func (s *indexedStore) get(key string) (runtime.Object, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	obj, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	return obj.DeepCopyObject(), true
+}
+
+// getAll returns deep copies of the objects with keys, in their order, and the keys not found
+func (s *indexedStore) getAll(keys []string) ([]runtime.Object, []string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	objs := make([]runtime.Object, 0, len(keys))
+	var missing []string
+	for _, key := range keys {
+		obj, ok := s.items[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		objs = append(objs, obj.DeepCopyObject())
+	}
+	return objs, missing
+}
+
+// list returns deep copies of all objects
+func (s *indexedStore) list() []runtime.Object {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	objs := make([]runtime.Object, 0, len(s.items))
+	for _, obj := range s.items {
+		objs = append(objs, obj.DeepCopyObject())
+	}
+	return objs
+}
+
+// byIndex returns deep copies of the objects whose index value matches
+func (s *indexedStore) byIndex(indexName, value string) []runtime.Object {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := s.indices[indexName][value]
+	objs := make([]runtime.Object, 0, len(keys))
+	for _, key := range keys.List() {
+		objs = append(objs, s.items[key].DeepCopyObject())
+	}
+	return objs
+}
+
+func (s *indexedStore) add(obj runtime.Object) {
+	key, err := objectKey(obj)
+	if err != nil {
+		glog.Errorf("get object key failed: %v", err)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.deleteFromIndices(key)
+	s.items[key] = obj
+	s.addToIndices(s.indices, key, obj)
+}
+
+// addToIndices adds the index values of obj to indices, which must not be read concurrently
+func (s *indexedStore) addToIndices(indices map[string]map[string]sets.String, key string, obj runtime.Object) {
+	for name, fn := range s.indexers {
+		for _, value := range fn(obj) {
+			if _, ok := indices[name][value]; !ok {
+				indices[name][value] = sets.NewString()
+			}
+			indices[name][value].Insert(key)
+		}
+	}
+}
+
+func (s *indexedStore) delete(obj runtime.Object) {
+	key, err := objectKey(obj)
+	if err != nil {
+		glog.Errorf("get object key failed: %v", err)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.deleteFromIndices(key)
+	delete(s.items, key)
+}
+
+// replace swaps all objects and indices for objs at once, so readers never see a partial store,
+// it returns the objects dropped whose keys are not in objs, i.e. those deleted since the last list
+func (s *indexedStore) replace(objs []runtime.Object) []runtime.Object {
+	items := make(map[string]runtime.Object, len(objs))
+	for _, obj := range objs {
+		key, err := objectKey(obj)
+		if err != nil {
+			glog.Errorf("get object key failed: %v", err)
+			continue
+		}
+		items[key] = obj
+	}
+	indices := make(map[string]map[string]sets.String)
+	for name := range s.indexers {
+		indices[name] = make(map[string]sets.String)
+	}
+	for key, obj := range items {
+		s.addToIndices(indices, key, obj)
+	}
+
+	s.lock.Lock()
+	old := s.items
+	s.items = items
+	s.indices = indices
+	s.lock.Unlock()
+
+	deleted := make([]runtime.Object, 0)
+	for key, obj := range old {
+		if _, ok := items[key]; !ok {
+			deleted = append(deleted, obj)
+		}
+	}
+	return deleted
+}
+
+// deleteFromIndices must be called with the lock held
+func (s *indexedStore) deleteFromIndices(key string) {
+	old, ok := s.items[key]
+	if !ok {
+		return
+	}
+	for name, fn := range s.indexers {
+		for _, value := range fn(old) {
+			if keys, ok := s.indices[name][value]; ok {
+				keys.Delete(key)
+				if keys.Len() == 0 {
+					delete(s.indices[name], value)
+				}
+			}
+		}
+	}
+}
+
+// reflector lists and watches one kind of resource and keeps the store up to date
+type reflector struct {
+	name      string
+	listFunc  func() ([]runtime.Object, string, error)
+	watchFunc func(options metav1.ListOptions) (watch.Interface, error)
+	store     *indexedStore
+
+	lock     sync.RWMutex
+	synced   bool
+	handlers []ResourceEventHandler
+}
+
+// observeWatch observes the duration of the requests opening a watch on resource
+func observeWatch(resource string, watchFunc func(options metav1.ListOptions) (watch.Interface, error)) func(options metav1.ListOptions) (watch.Interface, error) {
+	return func(options metav1.ListOptions) (watch.Interface, error) {
+		defer observeAPIRequest("watch", resource, time.Now())
+		return watchFunc(options)
+	}
+}
+
+func newReflector(name string, listFunc func() ([]runtime.Object, string, error), watchFunc func(options metav1.ListOptions) (watch.Interface, error), indexers map[string]indexFunc) *reflector {
+	return &reflector{
+		name:      name,
+		listFunc:  listFunc,
+		watchFunc: watchFunc,
+		store:     newIndexedStore(indexers),
+	}
+}
+
+func (r *reflector) addEventHandler(handler ResourceEventHandler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.handlers = append(r.handlers, handler)
+}
+
+// notify calls the handlers with a copy of obj after the store has been updated
+func (r *reflector) notify(eventType watch.EventType, obj runtime.Object) {
+	r.lock.RLock()
+	handlers := r.handlers
+	r.lock.RUnlock()
+	for _, handler := range handlers {
+		handler(eventType, obj.DeepCopyObject())
+	}
+}
+
+func (r *reflector) hasSynced() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.synced
+}
+
+func (r *reflector) run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := r.listAndWatch(stopCh); err != nil {
+			glog.Errorf("list and watch %s failed: %v", r.name, err)
+		}
+	}, relistPeriod, stopCh)
+}
+
+// listAndWatch lists all objects and then watches from the list resource version.
+// It returns when stopCh is closed or the watch can not be continued.
+func (r *reflector) listAndWatch(stopCh <-chan struct{}) error {
+	objs, resourceVersion, err := r.listFunc()
+	if err != nil {
+		return err
+	}
+	deleted := r.store.replace(objs)
+	for _, obj := range deleted {
+		r.notify(watch.Deleted, obj)
+	}
+	for _, obj := range objs {
+		r.notify(watch.Added, obj)
+	}
+	r.lock.Lock()
+	r.synced = true
+	r.lock.Unlock()
+	glog.V(3).Infof("listed %d %s at resource version %s", len(objs), r.name, resourceVersion)
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+		timeoutSeconds := int64(wait.Jitter(watchTimeout, 1).Seconds())
+		w, err := r.watchFunc(metav1.ListOptions{
+			ResourceVersion: resourceVersion,
+			TimeoutSeconds:  &timeoutSeconds,
+		})
+		if err != nil {
+			return err
+		}
+		resourceVersion, err = r.watchHandler(w, resourceVersion, stopCh)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// watchHandler applies watch events to the store and returns the last seen resource version
+func (r *reflector) watchHandler(w watch.Interface, resourceVersion string, stopCh <-chan struct{}) (string, error) {
+	defer w.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return resourceVersion, nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion, nil
+			}
+			if event.Type == watch.Error {
+				return resourceVersion, fmt.Errorf("watch %s error: %v", r.name, event.Object)
+			}
+			accessor, err := meta.Accessor(event.Object)
+			if err != nil {
+				glog.Errorf("unexpected %s watch object: %v", r.name, err)
+				continue
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				r.store.add(event.Object)
+			case watch.Deleted:
+				r.store.delete(event.Object)
+			}
+			r.notify(event.Type, event.Object)
+			resourceVersion = accessor.GetResourceVersion()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestIndexedStore(t *testing.T) {
+	store := newIndexedStore(map[string]indexFunc{
+		indexByPhase: func(obj runtime.Object) []string {
+			return []string{string(obj.(*v1alpha1.ExtendedResource).Status.Phase)}
+		},
+	})
+
+	store.replace([]runtime.Object{
+		newTestER("er1", nil),
+		newTestER("er2", nil),
+	})
+	if n := len(store.byIndex(indexByPhase, string(v1alpha1.ExtendedResourceAvailable))); n != 2 {
+		t.Fatalf("expected 2 available extendedresources, got %d", n)
+	}
+
+	store.add(withPhase(newTestER("er1", nil), v1alpha1.ExtendedResourceBound))
+	if n := len(store.byIndex(indexByPhase, string(v1alpha1.ExtendedResourceAvailable))); n != 1 {
+		t.Fatalf("expected 1 available extendedresource after update, got %d", n)
+	}
+	bound := store.byIndex(indexByPhase, string(v1alpha1.ExtendedResourceBound))
+	if len(bound) != 1 || bound[0].(*v1alpha1.ExtendedResource).Name != "er1" {
+		t.Fatalf("expected er1 to be bound, got %v", bound)
+	}
+
+	// modify the returned copy must not change the store
+	obj, _ := store.get("er2")
+	obj.(*v1alpha1.ExtendedResource).Status.Phase = v1alpha1.ExtendedResourceBound
+	if n := len(store.byIndex(indexByPhase, string(v1alpha1.ExtendedResourceBound))); n != 1 {
+		t.Fatalf("store was modified through a returned object")
+	}
+
+	objs, missing := store.getAll([]string{"er2", "er3", "er1"})
+	if len(objs) != 2 || objs[0].(*v1alpha1.ExtendedResource).Name != "er2" || !reflect.DeepEqual(missing, []string{"er3"}) {
+		t.Fatalf("expected er2 and er1 with er3 missing, got %v %v", objs, missing)
+	}
+
+	store.delete(newTestER("er1", nil))
+	if _, ok := store.get("er1"); ok {
+		t.Fatalf("er1 should be deleted")
+	}
+	if n := len(store.byIndex(indexByPhase, string(v1alpha1.ExtendedResourceBound))); n != 0 {
+		t.Fatalf("expected no bound extendedresource after delete, got %d", n)
+	}
+}
+
+func TestIndexedStoreReplaceConcurrentReads(t *testing.T) {
+	store := newIndexedStore(map[string]indexFunc{
+		indexByPhase: func(obj runtime.Object) []string {
+			return []string{string(obj.(*v1alpha1.ExtendedResource).Status.Phase)}
+		},
+	})
+	objs := make([]runtime.Object, 0, 100)
+	for i := 0; i < 100; i++ {
+		objs = append(objs, newTestER("er"+strconv.Itoa(i), nil))
+	}
+	store.replace(objs)
+
+	// er0 is listed before and after every relist, so no reader may miss it
+	stopCh := make(chan struct{})
+	missed := make(chan int)
+	go func() {
+		count := 0
+		for {
+			select {
+			case <-stopCh:
+				missed <- count
+				return
+			default:
+			}
+			if _, ok := store.get("er0"); !ok {
+				count++
+			}
+			if len(store.byIndex(indexByPhase, string(v1alpha1.ExtendedResourceAvailable))) != len(objs) {
+				count++
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		store.replace(objs)
+	}
+	close(stopCh)
+	if count := <-missed; count != 0 {
+		t.Errorf("expected no missed reads during relist, got %d", count)
+	}
+}
+
+func TestReflectorRelist(t *testing.T) {
+	lists := [][]runtime.Object{
+		{newTestER("er1", nil), newTestER("er2", nil)},
+		{newTestER("er2", nil)},
+	}
+	calls := 0
+	r := newReflector("extendedresources", func() ([]runtime.Object, string, error) {
+		objs := lists[calls]
+		calls++
+		return objs, strconv.Itoa(calls), nil
+	}, func(options metav1.ListOptions) (watch.Interface, error) {
+		return nil, errors.New("watch is down")
+	}, nil)
+	events := make([]string, 0)
+	r.addEventHandler(func(eventType watch.EventType, obj runtime.Object) {
+		events = append(events, fmt.Sprintf("%s %s", eventType, obj.(*v1alpha1.ExtendedResource).Name))
+	})
+
+	// er1 is deleted while the watch is down, the relist must report it
+	for range lists {
+		if err := r.listAndWatch(nil); err == nil {
+			t.Fatalf("expected the watch to fail")
+		}
+	}
+	expected := []string{"ADDED er1", "ADDED er2", "DELETED er1", "ADDED er2"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+	if _, ok := r.store.get("er1"); ok {
+		t.Errorf("expected er1 to be dropped from the store")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// ClaimController drives the lifecycle of bound extendedresourceclaims.
+// A bound claim becomes Lost when one of its extended resources is deleted, bound to another claim
+// or no longer allocatable on the node of the pod using the claim, and becomes Bound again once
+// all of them are back.
+type ClaimController struct {
+	extendedResourceScheduler *ExtendedResourceScheduler
+	resyncPeriod              time.Duration
+	// syncCh is signaled when a watched object changed
+	syncCh chan struct{}
+}
+
+// NewClaimController creates a ClaimController which reads from the cache of extendedResourceScheduler
+func NewClaimController(extendedResourceScheduler *ExtendedResourceScheduler, resyncPeriod time.Duration) *ClaimController {
+	c := &ClaimController{
+		extendedResourceScheduler: extendedResourceScheduler,
+		resyncPeriod:              resyncPeriod,
+		syncCh:                    make(chan struct{}, 1),
+	}
+	extendedResourceScheduler.Cache.AddEventHandler(func(eventType watch.EventType, obj runtime.Object) {
+		switch obj.(type) {
+		case *v1alpha1.ExtendedResource, *v1alpha1.ExtendedResourceClaim, *v1.Node:
+			c.enqueue()
+		}
+	})
+	return c
+}
+
+func (c *ClaimController) enqueue() {
+	select {
+	case c.syncCh <- struct{}{}:
+	default:
+	}
+}
+
+// Run syncs all claims on changes and every resyncPeriod until stopCh is closed
+func (c *ClaimController) Run(stopCh <-chan struct{}) {
+	glog.V(2).Info("claim controller is starting")
+	ticker := time.NewTicker(c.resyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			glog.V(2).Info("claim controller is stopped")
+			return
+		case <-c.syncCh:
+		case <-ticker.C:
+		}
+		c.syncAll()
+	}
+}
+
+func (c *ClaimController) syncAll() {
+	cache := c.extendedResourceScheduler.Cache
+	claims := append(cache.ListExtendedResourceClaimsByPhase(v1alpha1.ExtendedResourceClaimBound),
+		cache.ListExtendedResourceClaimsByPhase(v1alpha1.ExtendedResourceClaimLost)...)
+	for _, erc := range claims {
+		if err := c.syncClaim(erc); err != nil {
+			glog.Errorf("sync extendedresourceclaim %s/%s failed: %v", erc.Namespace, erc.Name, err)
+		}
+	}
+}
+
+// syncClaim moves a Bound claim to Lost, or a Lost claim back to Bound, according to its extended resources
+func (c *ClaimController) syncClaim(erc *v1alpha1.ExtendedResourceClaim) error {
+	lostReason := lostExtendedResources(erc, c.extendedResourceScheduler.Cache)
+
+	var phase v1alpha1.ExtendedResourceClaimPhase
+	var reason string
+	switch {
+	case erc.Status.Phase == v1alpha1.ExtendedResourceClaimBound && lostReason != "":
+		phase, reason = v1alpha1.ExtendedResourceClaimLost, lostReason
+	case erc.Status.Phase == v1alpha1.ExtendedResourceClaimLost && lostReason == "":
+		phase, reason = v1alpha1.ExtendedResourceClaimBound, claimBoundReason
+	case erc.Status.Phase == v1alpha1.ExtendedResourceClaimLost && lostReason != erc.Status.Reason:
+		phase, reason = v1alpha1.ExtendedResourceClaimLost, lostReason
+	default:
+		return nil
+	}
+
+	glog.V(2).Infof("extendedresourceclaim %s/%s is %s: %s", erc.Namespace, erc.Name, phase, reason)
+	from := erc.Status.Phase
+	updated, err := c.extendedResourceScheduler.UpdateExtendedResourceClaim(erc, func(erc *v1alpha1.ExtendedResourceClaim) error {
+		if erc.Status.Phase != from {
+			return fmt.Errorf("phase changed from %s to %s by others", from, erc.Status.Phase)
+		}
+		erc.Status.Phase = phase
+		erc.Status.Reason = reason
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if phase == v1alpha1.ExtendedResourceClaimLost {
+		c.extendedResourceScheduler.Recorder.Eventf(updated, v1.EventTypeWarning, reasonClaimLost, "%s", reason)
+	} else {
+		c.extendedResourceScheduler.Recorder.Eventf(updated, v1.EventTypeNormal, reasonClaimRecovered,
+			"extended resources %s are in place again", strings.Join(updated.Spec.ExtendedResourceNames, ","))
+	}
+	return nil
+}
+
+// lostExtendedResources returns why the extended resources of a bound claim are lost,
+// or an empty string if all of them are still in place
+func lostExtendedResources(erc *v1alpha1.ExtendedResourceClaim, cache *ResourceCache) string {
+	// the claim is expected on the node of the pods using it, or on any node if they are not scheduled
+	nodeNames := make([]string, 0)
+	for _, pod := range cache.ListPodsByClaim(erc.Namespace, erc.Name) {
+		if pod.Spec.NodeName != "" && !containsString(nodeNames, pod.Spec.NodeName) {
+			nodeNames = append(nodeNames, pod.Spec.NodeName)
+		}
+	}
+
+	deleted := make([]string, 0)
+	rebound := make([]string, 0)
+	removed := make([]string, 0)
+	for _, name := range erc.Spec.ExtendedResourceNames {
+		er, err := cache.GetExtendedResource(name)
+		if err != nil {
+			deleted = append(deleted, name)
+			continue
+		}
+		if er.Spec.ExtendedResourceClaimName != "" && er.Spec.ExtendedResourceClaimName != erc.Name {
+			rebound = append(rebound, name)
+			continue
+		}
+		if !extendedResourceOnNodes(name, nodeNames, cache) {
+			removed = append(removed, name)
+		}
+	}
+
+	reasons := make([]string, 0)
+	if len(deleted) > 0 {
+		reasons = append(reasons, fmt.Sprintf("extended resources [%s] are deleted", strings.Join(deleted, " ")))
+	}
+	if len(rebound) > 0 {
+		reasons = append(reasons, fmt.Sprintf("extended resources [%s] are bound to other claims", strings.Join(rebound, " ")))
+	}
+	if len(removed) > 0 {
+		reasons = append(reasons, fmt.Sprintf("extended resources [%s] are no longer allocatable on the node", strings.Join(removed, " ")))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// extendedResourceOnNodes checks whether the extended resource is allocatable on every one of nodeNames,
+// or on any node if nodeNames is empty
+func extendedResourceOnNodes(erName string, nodeNames []string, cache *ResourceCache) bool {
+	nodes := cache.ListNodesByExtendedResource(erName)
+	if len(nodeNames) == 0 {
+		return len(nodes) > 0
+	}
+	for _, nodeName := range nodeNames {
+		found := false
+		for _, node := range nodes {
+			if node.Name == nodeName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
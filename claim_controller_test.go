@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestLostExtendedResources(t *testing.T) {
+	newER := func(name, ercName string) *v1alpha1.ExtendedResource {
+		return boundTo(newTestER(name, nil), ercName)
+	}
+	erc := &v1alpha1.ExtendedResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "erc1", Namespace: "default"},
+		Spec:       v1alpha1.ExtendedResourceClaimSpec{ExtendedResourceNames: []string{"er1", "er2"}},
+		Status:     v1alpha1.ExtendedResourceClaimStatus{Phase: v1alpha1.ExtendedResourceClaimBound},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default"},
+		Spec: v1.PodSpec{
+			NodeName:   "node1",
+			Containers: []v1.Container{{Name: "c1", ExtendedResourceClaims: []string{"erc1"}}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		ers      []*v1alpha1.ExtendedResource
+		nodes    []*v1.Node
+		pods     []*v1.Pod
+		expected string
+	}{
+		{
+			name:     "all extended resources in place",
+			ers:      []*v1alpha1.ExtendedResource{newER("er1", "erc1"), newER("er2", "erc1")},
+			nodes:    []*v1.Node{newTestNode("node1", "er1", "er2")},
+			pods:     []*v1.Pod{pod},
+			expected: "",
+		},
+		{
+			name:     "extended resource deleted",
+			ers:      []*v1alpha1.ExtendedResource{newER("er1", "erc1")},
+			nodes:    []*v1.Node{newTestNode("node1", "er1", "er2")},
+			pods:     []*v1.Pod{pod},
+			expected: "extended resources [er2] are deleted",
+		},
+		{
+			name:     "extended resource bound to another claim",
+			ers:      []*v1alpha1.ExtendedResource{newER("er1", "erc1"), newER("er2", "erc2")},
+			nodes:    []*v1.Node{newTestNode("node1", "er1", "er2")},
+			pods:     []*v1.Pod{pod},
+			expected: "extended resources [er2] are bound to other claims",
+		},
+		{
+			name:     "extended resource moved away from the node of the pod",
+			ers:      []*v1alpha1.ExtendedResource{newER("er1", "erc1"), newER("er2", "erc1")},
+			nodes:    []*v1.Node{newTestNode("node1", "er1"), newTestNode("node2", "er2")},
+			pods:     []*v1.Pod{pod},
+			expected: "extended resources [er2] are no longer allocatable on the node",
+		},
+		{
+			name:     "pod not scheduled, extended resources on any node",
+			ers:      []*v1alpha1.ExtendedResource{newER("er1", "erc1"), newER("er2", "erc1")},
+			nodes:    []*v1.Node{newTestNode("node1", "er1"), newTestNode("node2", "er2")},
+			expected: "",
+		},
+	}
+	for _, test := range tests {
+		objs := make([]runtime.Object, 0)
+		for _, er := range test.ers {
+			objs = append(objs, er)
+		}
+		for _, node := range test.nodes {
+			objs = append(objs, node)
+		}
+		for _, pod := range test.pods {
+			objs = append(objs, pod)
+		}
+		cache := newTestCache(objs...)
+		if got := lostExtendedResources(erc, cache); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
@@ -8,11 +8,19 @@ import (
 
 // CreateClientset is create a kubernetes client
 func CreateClientset(master, kubeConfig *string) (*kubernetes.Clientset, error) {
-	config, err := clientcmd.BuildConfigFromFlags(*master, *kubeConfig)
+	return CreateClientsetForConfig(ClientConfig{Master: *master, Kubeconfig: *kubeConfig})
+}
+
+// CreateClientsetForConfig is create a kubernetes client with the client configuration,
+// zero QPS and burst keep the client-go defaults
+func CreateClientsetForConfig(clientConfig ClientConfig) (*kubernetes.Clientset, error) {
+	config, err := clientcmd.BuildConfigFromFlags(clientConfig.Master, clientConfig.Kubeconfig)
 	if err != nil {
 		glog.Errorf("unable to build config: %v", err)
 		return nil, err
 	}
+	config.QPS = clientConfig.QPS
+	config.Burst = clientConfig.Burst
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// ConfigAPIVersion is the only supported version of the configuration file
+	ConfigAPIVersion = "extendedresource.scheduler/v1alpha1"
+	// ConfigKind is the kind of the configuration file
+	ConfigKind = "SchedulerConfiguration"
+
+	// ClaimControllerFeature enables the controller moving bound claims to Lost and back
+	ClaimControllerFeature = "ClaimController"
+	// ReleaseControllerFeature enables the controller releasing extended resources of terminated pods
+	ReleaseControllerFeature = "ReleaseController"
+)
+
+// knownFeatures are the feature toggles and whether they are enabled by default
+var knownFeatures = map[string]bool{
+	ClaimControllerFeature:   true,
+	ReleaseControllerFeature: true,
+}
+
+// Config is the configuration of the extender server
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	Server ServerConfig `json:"server"`
+	Client ClientConfig `json:"client"`
+
+	// PriorityStrategy is the strategy used to score nodes, binpack or spread
+	PriorityStrategy string `json:"priorityStrategy"`
+	// ReservationTTL is how long the allocation plans of a pod computed by filter are kept if bind does not arrive,
+	// and how long bind reserves the extended resources of the chosen plan
+	ReservationTTL metav1.Duration `json:"reservationTTL"`
+	// ClaimResyncPeriod is the period to check all bound claims for lost extended resources
+	ClaimResyncPeriod metav1.Duration `json:"claimResyncPeriod"`
+	// ReleaseResyncPeriod is the period to check all bound claims for terminated pods
+	ReleaseResyncPeriod metav1.Duration `json:"releaseResyncPeriod"`
+
+	LeaderElection LeaderElectionConfig `json:"leaderElection"`
+
+	// Storage is where objects are read from and written to, the apiserver unless a directory is given
+	Storage StorageConfig `json:"storage,omitempty"`
+
+	// Features turns optional parts of the scheduler on or off, features not listed keep their default
+	Features map[string]bool `json:"features,omitempty"`
+}
+
+// ServerConfig is the configuration of the http server serving the extender verbs
+type ServerConfig struct {
+	// Address is the address the server listens on
+	Address string `json:"address"`
+	// URLPrefix is the path all verbs are served under, it matches the urlPrefix of the extender policy
+	URLPrefix string `json:"urlPrefix"`
+	// PredicatesPath, PrioritizePath and BindPath are the verbs configured in the extender policy
+	PredicatesPath string `json:"predicatesPath"`
+	PrioritizePath string `json:"prioritizePath"`
+	BindPath       string `json:"bindPath"`
+
+	ReadTimeout  metav1.Duration `json:"readTimeout"`
+	WriteTimeout metav1.Duration `json:"writeTimeout"`
+	// ShutdownGracePeriod is how long requests in flight are waited for on SIGTERM, binds still running
+	// when two thirds of it have passed are rolled back in the rest
+	ShutdownGracePeriod metav1.Duration `json:"shutdownGracePeriod"`
+
+	// TLS serves the verbs over https, plain http is served if it is not given
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// TLSConfig is the certificate of the server and the CA used to verify clients,
+// the files are reloaded when they change
+type TLSConfig struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// ClientCAFile requires clients of the extender verbs, i.e. kube-scheduler, to present a certificate signed
+	// by the CA if given, the health, readiness and metrics endpoints are served without one
+	ClientCAFile string `json:"clientCAFile,omitempty"`
+}
+
+// LeaderElectionConfig is the configuration of the leader election among scheduler replicas.
+// Only the leader binds and runs the controllers, the others serve filter and prioritize.
+type LeaderElectionConfig struct {
+	LeaderElect bool `json:"leaderElect"`
+	// LockNamespace and LockName are the configmap used as the lock
+	LockNamespace string `json:"lockNamespace"`
+	LockName      string `json:"lockName"`
+	// LeaseDuration is how long the other replicas wait after the last renewal before taking the lock
+	LeaseDuration metav1.Duration `json:"leaseDuration"`
+	// RenewDeadline is how long the leader keeps trying to renew before it stops leading
+	RenewDeadline metav1.Duration `json:"renewDeadline"`
+	// RetryPeriod is the interval between tries to acquire or renew the lock
+	RetryPeriod metav1.Duration `json:"retryPeriod"`
+}
+
+// StorageConfig selects a storage other than the apiserver
+type StorageConfig struct {
+	// Directory runs the scheduler on the objects of the yaml and json files in the directory, no cluster is needed.
+	// The controllers, events and leader election need the apiserver, so they are turned off.
+	Directory string `json:"directory,omitempty"`
+}
+
+// ClientConfig is the configuration of the client talking to the kubernetes apiserver
+type ClientConfig struct {
+	Master     string  `json:"master"`
+	Kubeconfig string  `json:"kubeconfig"`
+	QPS        float32 `json:"qps"`
+	Burst      int     `json:"burst"`
+}
+
+// LoadConfig reads the configuration file and sets defaults for the fields not given,
+// it is validated by ValidateConfig once command line flags are applied
+func LoadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %v", filename, err)
+	}
+	if config.APIVersion != ConfigAPIVersion || config.Kind != ConfigKind {
+		return nil, fmt.Errorf("config file %s: unsupported apiVersion %q and kind %q, expected %s %s",
+			filename, config.APIVersion, config.Kind, ConfigAPIVersion, ConfigKind)
+	}
+	SetConfigDefaults(config)
+	return config, nil
+}
+
+// NewDefaultConfig returns the configuration used when no configuration file is given
+func NewDefaultConfig() *Config {
+	config := &Config{APIVersion: ConfigAPIVersion, Kind: ConfigKind}
+	SetConfigDefaults(config)
+	return config
+}
+
+// SetConfigDefaults sets the default of every field left empty
+func SetConfigDefaults(config *Config) {
+	server := &config.Server
+	if server.Address == "" {
+		server.Address = ":8089"
+	}
+	if server.URLPrefix == "" {
+		server.URLPrefix = "/scheduler"
+	}
+	if server.PredicatesPath == "" {
+		server.PredicatesPath = "predicates"
+	}
+	if server.PrioritizePath == "" {
+		server.PrioritizePath = "prioritize"
+	}
+	if server.BindPath == "" {
+		server.BindPath = "bind"
+	}
+	if server.ReadTimeout.Duration == 0 {
+		server.ReadTimeout.Duration = 10 * time.Second
+	}
+	if server.WriteTimeout.Duration == 0 {
+		server.WriteTimeout.Duration = 10 * time.Second
+	}
+	if server.ShutdownGracePeriod.Duration == 0 {
+		server.ShutdownGracePeriod.Duration = 30 * time.Second
+	}
+
+	client := &config.Client
+	if client.Master == "" {
+		client.Master = "http://127.0.0.1:8080"
+	}
+	if client.Kubeconfig == "" {
+		if home := homeDir(); home != "" {
+			client.Kubeconfig = filepath.Join(home, ".kube", "config")
+		}
+	}
+	if client.QPS == 0 {
+		client.QPS = 5
+	}
+	if client.Burst == 0 {
+		client.Burst = 10
+	}
+
+	leaderElection := &config.LeaderElection
+	if leaderElection.LockNamespace == "" {
+		leaderElection.LockNamespace = "kube-system"
+	}
+	if leaderElection.LockName == "" {
+		leaderElection.LockName = "k8s-er-scheduler"
+	}
+	if leaderElection.LeaseDuration.Duration == 0 {
+		leaderElection.LeaseDuration.Duration = 15 * time.Second
+	}
+	if leaderElection.RenewDeadline.Duration == 0 {
+		leaderElection.RenewDeadline.Duration = 10 * time.Second
+	}
+	if leaderElection.RetryPeriod.Duration == 0 {
+		leaderElection.RetryPeriod.Duration = 2 * time.Second
+	}
+
+	if config.PriorityStrategy == "" {
+		config.PriorityStrategy = BinPackStrategy
+	}
+	if config.ReservationTTL.Duration == 0 {
+		config.ReservationTTL.Duration = 30 * time.Second
+	}
+	if config.ClaimResyncPeriod.Duration == 0 {
+		config.ClaimResyncPeriod.Duration = 30 * time.Second
+	}
+	if config.ReleaseResyncPeriod.Duration == 0 {
+		config.ReleaseResyncPeriod.Duration = time.Minute
+	}
+}
+
+// ValidateConfig returns all the errors found in the configuration
+func ValidateConfig(config *Config) error {
+	errs := make([]error, 0)
+	server := config.Server
+	if server.Address == "" {
+		errs = append(errs, fmt.Errorf("server.address must not be empty"))
+	}
+	if !strings.HasPrefix(server.URLPrefix, "/") {
+		errs = append(errs, fmt.Errorf("server.urlPrefix %q must start with /", server.URLPrefix))
+	}
+	verbs := map[string]string{
+		"server.predicatesPath": server.PredicatesPath,
+		"server.prioritizePath": server.PrioritizePath,
+		"server.bindPath":       server.BindPath,
+	}
+	seen := make(map[string]string)
+	for _, field := range []string{"server.predicatesPath", "server.prioritizePath", "server.bindPath"} {
+		verb := verbs[field]
+		if verb == "" || strings.Contains(verb, "/") {
+			errs = append(errs, fmt.Errorf("%s %q must be a non-empty path segment", field, verb))
+			continue
+		}
+		if p := config.VerbPath(verb); p == healthzPath || p == readyzPath || p == metricsPath || p == explainPath {
+			errs = append(errs, fmt.Errorf("%s %q is reserved", field, p))
+		}
+		if other, ok := seen[verb]; ok {
+			errs = append(errs, fmt.Errorf("%s %q is the same as %s", field, verb, other))
+		}
+		seen[verb] = field
+	}
+	if server.ReadTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("server.readTimeout must be positive"))
+	}
+	if server.WriteTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("server.writeTimeout must be positive"))
+	}
+	if server.ShutdownGracePeriod.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("server.shutdownGracePeriod must be positive"))
+	}
+	if tlsConfig := server.TLS; tlsConfig != nil {
+		if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+			errs = append(errs, fmt.Errorf("server.tls.certFile and server.tls.keyFile must be given together"))
+		}
+	}
+
+	if config.Client.QPS <= 0 {
+		errs = append(errs, fmt.Errorf("client.qps must be positive"))
+	}
+	if config.Client.Burst <= 0 {
+		errs = append(errs, fmt.Errorf("client.burst must be positive"))
+	}
+
+	if leaderElection := config.LeaderElection; leaderElection.LeaderElect {
+		if leaderElection.LockNamespace == "" || leaderElection.LockName == "" {
+			errs = append(errs, fmt.Errorf("leaderElection.lockNamespace and leaderElection.lockName must not be empty"))
+		}
+		if leaderElection.RetryPeriod.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("leaderElection.retryPeriod must be positive"))
+		}
+		if leaderElection.RenewDeadline.Duration <= leaderElection.RetryPeriod.Duration {
+			errs = append(errs, fmt.Errorf("leaderElection.renewDeadline must be greater than leaderElection.retryPeriod"))
+		}
+		if leaderElection.LeaseDuration.Duration <= leaderElection.RenewDeadline.Duration {
+			errs = append(errs, fmt.Errorf("leaderElection.leaseDuration must be greater than leaderElection.renewDeadline"))
+		}
+	}
+
+	if config.Storage.Directory != "" && config.LeaderElection.LeaderElect {
+		errs = append(errs, fmt.Errorf("leaderElection.leaderElect needs the apiserver, it can not be used with storage.directory"))
+	}
+
+	if err := ValidatePriorityStrategy(config.PriorityStrategy); err != nil {
+		errs = append(errs, err)
+	}
+	if config.ReservationTTL.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("reservationTTL must be positive"))
+	}
+	if config.ClaimResyncPeriod.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("claimResyncPeriod must be positive"))
+	}
+	if config.ReleaseResyncPeriod.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("releaseResyncPeriod must be positive"))
+	}
+	for feature := range config.Features {
+		if _, ok := knownFeatures[feature]; !ok {
+			errs = append(errs, fmt.Errorf("unknown feature %q", feature))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// FeatureEnabled returns whether the feature is turned on, either explicitly or by default
+func (c *Config) FeatureEnabled(feature string) bool {
+	if enabled, ok := c.Features[feature]; ok {
+		return enabled
+	}
+	return knownFeatures[feature]
+}
+
+// VerbPath returns the url path a verb is served on
+func (c *Config) VerbPath(verb string) string {
+	return path.Join(c.Server.URLPrefix, verb)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		data   string
+		err    string
+		verify func(*Config) string
+	}{
+		{
+			name: "example file",
+			data: readExample(t, "examples/config.yaml"),
+			verify: func(c *Config) string {
+				if c.VerbPath(c.Server.BindPath) != "/scheduler/bind" {
+					return "bind path " + c.VerbPath(c.Server.BindPath)
+				}
+				return ""
+			},
+		},
+		{
+			name: "fields not given are defaulted",
+			data: "apiVersion: extendedresource.scheduler/v1alpha1\nkind: SchedulerConfiguration\nserver:\n  urlPrefix: /er\n",
+			verify: func(c *Config) string {
+				if c.Server.Address != ":8089" || c.Server.ReadTimeout.Duration != 10*time.Second || c.PriorityStrategy != BinPackStrategy {
+					return "defaults are not set"
+				}
+				if c.VerbPath(c.Server.PredicatesPath) != "/er/predicates" {
+					return "predicates path " + c.VerbPath(c.Server.PredicatesPath)
+				}
+				return ""
+			},
+		},
+		{
+			name: "features not given keep their default",
+			data: "apiVersion: extendedresource.scheduler/v1alpha1\nkind: SchedulerConfiguration\nfeatures:\n  ReleaseController: false\n",
+			verify: func(c *Config) string {
+				if !c.FeatureEnabled(ClaimControllerFeature) || c.FeatureEnabled(ReleaseControllerFeature) {
+					return "unexpected features"
+				}
+				return ""
+			},
+		},
+		{
+			name: "unsupported version",
+			data: "apiVersion: v2\nkind: SchedulerConfiguration\n",
+			err:  "unsupported apiVersion",
+		},
+	}
+	for i, test := range tests {
+		filename := filepath.Join(dir, fmt.Sprintf("%d.yaml", i))
+		if err := ioutil.WriteFile(filename, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(filename)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if err := ValidateConfig(config); err != nil {
+			t.Errorf("%s: unexpected invalid config: %v", test.name, err)
+		}
+		if msg := test.verify(config); msg != "" {
+			t.Errorf("%s: %s", test.name, msg)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		err    string
+	}{
+		{
+			name:   "default config",
+			modify: func(*Config) {},
+		},
+		{
+			name:   "relative url prefix",
+			modify: func(c *Config) { c.Server.URLPrefix = "scheduler" },
+			err:    "must start with /",
+		},
+		{
+			name:   "same verb paths",
+			modify: func(c *Config) { c.Server.BindPath = c.Server.PredicatesPath },
+			err:    "server.bindPath \"predicates\" is the same as server.predicatesPath",
+		},
+		{
+			name:   "verb path taken by probes",
+			modify: func(c *Config) { c.Server.URLPrefix, c.Server.PredicatesPath = "/", "healthz" },
+			err:    "server.predicatesPath \"/healthz\" is reserved",
+		},
+		{
+			name:   "negative timeout",
+			modify: func(c *Config) { c.Server.WriteTimeout.Duration = -time.Second },
+			err:    "server.writeTimeout must be positive",
+		},
+		{
+			name:   "tls key not given",
+			modify: func(c *Config) { c.Server.TLS = &TLSConfig{CertFile: "tls.crt"} },
+			err:    "server.tls.certFile and server.tls.keyFile must be given together",
+		},
+		{
+			name: "renew deadline longer than lease",
+			modify: func(c *Config) {
+				c.LeaderElection.LeaderElect = true
+				c.LeaderElection.RenewDeadline.Duration = time.Minute
+			},
+			err: "leaderElection.leaseDuration must be greater than leaderElection.renewDeadline",
+		},
+		{
+			name: "leader election with storage directory",
+			modify: func(c *Config) {
+				c.LeaderElection.LeaderElect = true
+				c.Storage.Directory = "examples/simulate"
+			},
+			err: "leaderElection.leaderElect needs the apiserver",
+		},
+		{
+			name:   "unknown strategy",
+			modify: func(c *Config) { c.PriorityStrategy = "random" },
+			err:    "random",
+		},
+		{
+			name:   "unknown feature",
+			modify: func(c *Config) { c.Features = map[string]bool{"Foo": true} },
+			err:    "unknown feature \"Foo\"",
+		},
+	}
+	for _, test := range tests {
+		config := NewDefaultConfig()
+		test.modify(config)
+		err := ValidateConfig(config)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func readExample(t *testing.T, filename string) string {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
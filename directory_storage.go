@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// yamlDocumentSeparator separates the objects in a yaml file
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// DirectoryStorage is a Storage holding the objects of the yaml and json files in a directory.
+// The files are read once when it is created, a file is written again whenever an object in it changes,
+// so the comments of a changed file are lost. Documents of other kinds are written back as they are read.
+type DirectoryStorage struct {
+	*MemoryStorage
+	// files holds the documents of every file in the order they are read
+	files map[string][]storedDocument
+	// origins maps the resource and key of every object to the file it is read from
+	origins map[string]string
+}
+
+// storedDocument is a document of a file, either an object kept in the memory storage or a raw document
+type storedDocument struct {
+	typeMeta      metav1.TypeMeta
+	resource, key string
+	raw           string
+}
+
+// NewDirectoryStorage reads the objects of the yaml and json files in dir
+func NewDirectoryStorage(dir string) (*DirectoryStorage, error) {
+	files, err := objectFiles([]string{dir})
+	if err != nil {
+		return nil, err
+	}
+	s := &DirectoryStorage{
+		MemoryStorage: NewMemoryStorage(),
+		files:         make(map[string][]storedDocument),
+		origins:       make(map[string]string),
+	}
+	for _, file := range files {
+		documents, err := readDocuments(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, document := range documents {
+			obj, typeMeta, err := decodeObject([]byte(document))
+			if err != nil {
+				return nil, fmt.Errorf("decode an object in %s: %v", file, err)
+			}
+			if obj == nil {
+				s.files[file] = append(s.files[file], storedDocument{raw: document})
+				continue
+			}
+			resource, key, err := storageKey(obj)
+			if err != nil {
+				return nil, err
+			}
+			if other, ok := s.origins[resource+"/"+key]; ok {
+				return nil, fmt.Errorf("%s %s is found in both %s and %s", typeMeta.Kind, key, other, file)
+			}
+			if err := s.Add(obj); err != nil {
+				return nil, err
+			}
+			s.origins[resource+"/"+key] = file
+			s.files[file] = append(s.files[file], storedDocument{typeMeta: typeMeta, resource: resource, key: key})
+		}
+	}
+	s.written = s.writeFile
+	return s, nil
+}
+
+// writeFile writes the file the changed object is read from, it is called with the lock of the memory storage held
+func (s *DirectoryStorage) writeFile(resource, key string) error {
+	file, ok := s.origins[resource+"/"+key]
+	if !ok {
+		// added by Add, it is only kept in memory
+		return nil
+	}
+	asJSON := filepath.Ext(file) == ".json"
+	var buf bytes.Buffer
+	for i, document := range s.files[file] {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		if document.resource == "" {
+			buf.WriteString(document.raw)
+			continue
+		}
+		obj := s.objects[document.resource][document.key].DeepCopyObject()
+		obj.GetObjectKind().SetGroupVersionKind(document.typeMeta.GroupVersionKind())
+		var data []byte
+		var err error
+		if asJSON {
+			if data, err = json.MarshalIndent(obj, "", "  "); err == nil {
+				data = append(data, '\n')
+			}
+		} else {
+			data, err = yaml.Marshal(obj)
+		}
+		if err != nil {
+			return fmt.Errorf("encode %s %s: %v", document.typeMeta.Kind, document.key, err)
+		}
+		buf.Write(data)
+	}
+
+	// the file is replaced at once, so it is never left half written
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// objectFiles returns the files, and the yaml and json files in the directories
+func objectFiles(paths []string) ([]string, error) {
+	files := make([]string, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			switch filepath.Ext(entry.Name()) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// readDocuments returns the documents of a file separated by "---", without the empty ones
+func readDocuments(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	documents := make([]string, 0)
+	for _, document := range yamlDocumentSeparator.Split(string(data), -1) {
+		if document = strings.TrimSpace(document); document != "" {
+			documents = append(documents, document+"\n")
+		}
+	}
+	return documents, nil
+}
+
+// decodeObject decodes a pod, node, extendedresource or extendedresourceclaim by its kind,
+// the object is nil for other kinds. Pods and claims without a namespace are put in the default one.
+func decodeObject(data []byte) (runtime.Object, metav1.TypeMeta, error) {
+	var typeMeta metav1.TypeMeta
+	if err := yaml.Unmarshal(data, &typeMeta); err != nil {
+		return nil, typeMeta, err
+	}
+	var obj runtime.Object
+	switch typeMeta.Kind {
+	case "Node":
+		obj = &v1.Node{}
+	case "ExtendedResource":
+		obj = &v1alpha1.ExtendedResource{}
+	case "ExtendedResourceClaim":
+		obj = &v1alpha1.ExtendedResourceClaim{}
+	case "Pod":
+		obj = &v1.Pod{}
+	default:
+		return nil, typeMeta, nil
+	}
+	if err := yaml.Unmarshal(data, obj); err != nil {
+		return nil, typeMeta, err
+	}
+	switch o := obj.(type) {
+	case *v1.Pod:
+		if o.Namespace == "" {
+			o.Namespace = metav1.NamespaceDefault
+		}
+	case *v1alpha1.ExtendedResourceClaim:
+		if o.Namespace == "" {
+			o.Namespace = metav1.NamespaceDefault
+		}
+	}
+	return obj, typeMeta, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDirectoryStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"cluster.yaml": `# gpus
+apiVersion: extensions/v1alpha1
+kind: ExtendedResource
+metadata:
+  name: er1
+spec:
+  rawResourceName: nvidia.com/gpu
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: kept
+---
+apiVersion: extensions/v1alpha1
+kind: ExtendedResource
+metadata:
+  name: er2
+`,
+		"pod.json": `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod1"}}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage, err := NewDirectoryStorage(dir)
+	if err != nil {
+		t.Fatalf("load directory failed: %v", err)
+	}
+	er, err := storage.GetExtendedResource("er1")
+	if err != nil {
+		t.Fatalf("get er1 failed: %v", err)
+	}
+	er.Status.Phase = v1alpha1.ExtendedResourceBound
+	if _, err := storage.UpdateExtendedResource(er); err != nil {
+		t.Fatalf("update er1 failed: %v", err)
+	}
+	binding := &v1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1"},
+		Target:     v1.ObjectReference{Kind: "Node", Name: "node1"},
+	}
+	if err := storage.Bind("default", binding); err != nil {
+		t.Fatalf("bind pod1 failed: %v", err)
+	}
+
+	// the changes are read again from the files, with the other documents kept
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cluster.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "kind: ConfigMap\nmetadata:\n  name: kept\n") {
+		t.Errorf("expected the configmap to be kept, got:\n%s", data)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(dir, "pod.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "{") {
+		t.Errorf("expected pod.json to stay json, got:\n%s", data)
+	}
+	reloaded, err := NewDirectoryStorage(dir)
+	if err != nil {
+		t.Fatalf("load directory again failed: %v", err)
+	}
+	if er, _ := reloaded.GetExtendedResource("er1"); er == nil || er.Status.Phase != v1alpha1.ExtendedResourceBound || er.Spec.RawResourceName != "nvidia.com/gpu" {
+		t.Errorf("expected er1 bound, got %+v", er)
+	}
+	if er, _ := reloaded.GetExtendedResource("er2"); er == nil {
+		t.Errorf("expected er2 to be kept")
+	}
+	if pod, _ := reloaded.GetPod("default", "pod1"); pod == nil || pod.Spec.NodeName != "node1" {
+		t.Errorf("expected pod1 on node1, got %+v", pod)
+	}
+
+	// an object must be in one file only, so it is written to one
+	duplicate := "apiVersion: extensions/v1alpha1\nkind: ExtendedResource\nmetadata:\n  name: er2\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "duplicate.yml"), []byte(duplicate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDirectoryStorage(dir); err == nil || !strings.Contains(err.Error(), "er2 is found in both") {
+		t.Errorf("expected er2 found twice, got %v", err)
+	}
+}
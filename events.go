@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// eventComponent is the source component of the events
+	eventComponent = "extendedresource-scheduler"
+	// eventQueueSize is the number of events waiting to be written, events beyond it are dropped
+	eventQueueSize = 1000
+	// eventAggregationWindow is how long a repeated event increases the count of the earlier one
+	// instead of creating a new event
+	eventAggregationWindow = 10 * time.Minute
+
+	// event reasons
+	reasonExtendedResourcesUnsatisfied = "ExtendedResourcesUnsatisfied"
+	reasonClaimsSatisfied              = "ClaimsSatisfied"
+	reasonFailedBinding                = "FailedBinding"
+	reasonClaimBound                   = "ClaimBound"
+	reasonExtendedResourceBound        = "ExtendedResourceBound"
+	reasonClaimReleased                = "ClaimReleased"
+	reasonExtendedResourceReleased     = "ExtendedResourceReleased"
+	reasonClaimLost                    = "ClaimLost"
+	reasonClaimRecovered               = "ClaimRecovered"
+)
+
+// EventRecorder writes Events about pods, claims and extended resources, so users can see the decisions
+// of the scheduler with kubectl describe. The vendored client-go has no event recorder, so events are
+// written by a single goroutine from a bounded queue, and repeated events are aggregated into one with a count.
+// All methods of a nil EventRecorder do nothing.
+type EventRecorder struct {
+	events corev1client.EventsGetter
+	host   string
+	queue  chan *v1.Event
+
+	lock sync.Mutex
+	// recent maps the aggregation key of an event to the event written last
+	recent map[string]*v1.Event
+
+	now func() time.Time
+}
+
+// NewEventRecorder creates an EventRecorder writing events through events
+func NewEventRecorder(events corev1client.EventsGetter, host string) *EventRecorder {
+	return &EventRecorder{
+		events: events,
+		host:   host,
+		queue:  make(chan *v1.Event, eventQueueSize),
+		recent: make(map[string]*v1.Event),
+		now:    time.Now,
+	}
+}
+
+// Eventf records an event about obj, which is a pod, an extendedresourceclaim or an extendedresource
+func (r *EventRecorder) Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	ref, ok := objectReference(obj)
+	if !ok {
+		glog.Errorf("can not record event %s about %T", reason, obj)
+		return
+	}
+	now := metav1.NewTime(r.now())
+	// events about cluster scoped objects are written in the default namespace, the same as for nodes
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%s", ref.Name, rand.String(10)),
+			Namespace: namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		Source:         v1.EventSource{Component: eventComponent, Host: r.host},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	select {
+	case r.queue <- event:
+	default:
+		glog.Warningf("event queue is full, dropping event %s about %s %s: %s", reason, ref.Kind, ref.Name, event.Message)
+	}
+}
+
+// Run writes the queued events until stopCh is closed
+func (r *EventRecorder) Run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case event := <-r.queue:
+			r.write(event)
+		}
+	}
+}
+
+func (r *EventRecorder) write(event *v1.Event) {
+	key := eventAggregationKey(event)
+	r.lock.Lock()
+	previous, ok := r.recent[key]
+	if ok && event.LastTimestamp.Sub(previous.LastTimestamp.Time) > eventAggregationWindow {
+		ok = false
+	}
+	r.lock.Unlock()
+
+	client := r.events.Events(event.Namespace)
+	var written *v1.Event
+	var err error
+	start := time.Now()
+	if ok {
+		update := previous.DeepCopy()
+		update.Count++
+		update.LastTimestamp = event.LastTimestamp
+		written, err = client.Update(update)
+		observeAPIRequest("update", "events", start)
+	}
+	if !ok || err != nil {
+		// the previous event may have been deleted by its ttl, a new one is created then
+		start = time.Now()
+		written, err = client.Create(event)
+		observeAPIRequest("create", "events", start)
+	}
+	if err != nil {
+		glog.Errorf("write event %s about %s %s failed: %v", event.Reason, event.InvolvedObject.Kind, event.InvolvedObject.Name, err)
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.recent) >= eventQueueSize {
+		r.recent = make(map[string]*v1.Event)
+	}
+	r.recent[key] = written
+}
+
+func eventAggregationKey(event *v1.Event) string {
+	ref := event.InvolvedObject
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s/%s", ref.Kind, ref.Namespace, ref.Name, ref.UID, event.Type, event.Reason, event.Message)
+}
+
+// objectReference returns the reference of a pod, an extendedresourceclaim or an extendedresource
+func objectReference(obj runtime.Object) (v1.ObjectReference, bool) {
+	var ref v1.ObjectReference
+	var meta metav1.ObjectMeta
+	switch o := obj.(type) {
+	case *v1.Pod:
+		ref.APIVersion, ref.Kind, meta = "v1", "Pod", o.ObjectMeta
+	case *v1alpha1.ExtendedResourceClaim:
+		ref.APIVersion, ref.Kind, meta = v1alpha1.SchemeGroupVersion.String(), "ExtendedResourceClaim", o.ObjectMeta
+	case *v1alpha1.ExtendedResource:
+		ref.APIVersion, ref.Kind, meta = v1alpha1.SchemeGroupVersion.String(), "ExtendedResource", o.ObjectMeta
+	default:
+		return ref, false
+	}
+	ref.Namespace = meta.Namespace
+	ref.Name = meta.Name
+	ref.UID = meta.UID
+	ref.ResourceVersion = meta.ResourceVersion
+	return ref, true
+}
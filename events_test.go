@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// fakeEvents keeps events in memory, the methods not used by the recorder are left unimplemented
+type fakeEvents struct {
+	corev1client.EventInterface
+	items map[string]*v1.Event
+}
+
+func (f *fakeEvents) Events(namespace string) corev1client.EventInterface {
+	return f
+}
+
+func (f *fakeEvents) Create(event *v1.Event) (*v1.Event, error) {
+	f.items[event.Name] = event.DeepCopy()
+	return event.DeepCopy(), nil
+}
+
+func (f *fakeEvents) Update(event *v1.Event) (*v1.Event, error) {
+	if _, ok := f.items[event.Name]; !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "events"}, event.Name)
+	}
+	f.items[event.Name] = event.DeepCopy()
+	return event.DeepCopy(), nil
+}
+
+func TestObjectReference(t *testing.T) {
+	tests := []struct {
+		name     string
+		obj      runtime.Object
+		expected v1.ObjectReference
+		ok       bool
+	}{
+		{
+			name:     "pod",
+			obj:      &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod", UID: "1"}},
+			expected: v1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "ns", Name: "pod", UID: "1"},
+			ok:       true,
+		},
+		{
+			name: "extendedresourceclaim",
+			obj:  &v1alpha1.ExtendedResourceClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "erc"}},
+			expected: v1.ObjectReference{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "ExtendedResourceClaim",
+				Namespace: "ns", Name: "erc"},
+			ok: true,
+		},
+		{
+			name:     "extendedresource",
+			obj:      &v1alpha1.ExtendedResource{ObjectMeta: metav1.ObjectMeta{Name: "er"}},
+			expected: v1.ObjectReference{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "ExtendedResource", Name: "er"},
+			ok:       true,
+		},
+		{
+			name: "node",
+			obj:  &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}},
+		},
+	}
+	for _, test := range tests {
+		ref, ok := objectReference(test.obj)
+		if ok != test.ok {
+			t.Errorf("%s: expected ok %v, got %v", test.name, test.ok, ok)
+		}
+		if ref != test.expected {
+			t.Errorf("%s: expected reference %+v, got %+v", test.name, test.expected, ref)
+		}
+	}
+}
+
+func TestEventRecorder(t *testing.T) {
+	events := &fakeEvents{items: make(map[string]*v1.Event)}
+	recorder := NewEventRecorder(events, "host")
+	now := time.Now()
+	recorder.now = func() time.Time { return now }
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod"}}
+	er := &v1alpha1.ExtendedResource{ObjectMeta: metav1.ObjectMeta{Name: "er"}}
+
+	flush := func() {
+		for len(recorder.queue) > 0 {
+			recorder.write(<-recorder.queue)
+		}
+	}
+	countOf := func(kind, reason string) (int32, int) {
+		var count int32
+		var n int
+		for _, event := range events.items {
+			if event.InvolvedObject.Kind == kind && event.Reason == reason {
+				count += event.Count
+				n++
+			}
+		}
+		return count, n
+	}
+
+	// repeated events are aggregated into one
+	recorder.Eventf(pod, v1.EventTypeWarning, reasonFailedBinding, "bind failed: %s", "conflict")
+	recorder.Eventf(pod, v1.EventTypeWarning, reasonFailedBinding, "bind failed: %s", "conflict")
+	recorder.Eventf(er, v1.EventTypeNormal, reasonExtendedResourceBound, "bound")
+	flush()
+	if count, n := countOf("Pod", reasonFailedBinding); count != 2 || n != 1 {
+		t.Errorf("expected one pod event with count 2, got %d events with count %d", n, count)
+	}
+	if count, n := countOf("ExtendedResource", reasonExtendedResourceBound); count != 1 || n != 1 {
+		t.Errorf("expected one extendedresource event with count 1, got %d events with count %d", n, count)
+	}
+	for _, event := range events.items {
+		if event.InvolvedObject.Kind == "ExtendedResource" && event.Namespace != metav1.NamespaceDefault {
+			t.Errorf("expected the event of a cluster scoped object in namespace %s, got %q", metav1.NamespaceDefault, event.Namespace)
+		}
+	}
+
+	// a repeat after the aggregation window creates a new event
+	now = now.Add(eventAggregationWindow + time.Second)
+	recorder.Eventf(pod, v1.EventTypeWarning, reasonFailedBinding, "bind failed: %s", "conflict")
+	flush()
+	if count, n := countOf("Pod", reasonFailedBinding); count != 3 || n != 2 {
+		t.Errorf("expected two pod events with count 3, got %d events with count %d", n, count)
+	}
+
+	// a deleted event is created again
+	events.items = make(map[string]*v1.Event)
+	recorder.Eventf(pod, v1.EventTypeWarning, reasonFailedBinding, "bind failed: %s", "conflict")
+	flush()
+	if count, n := countOf("Pod", reasonFailedBinding); count != 1 || n != 1 {
+		t.Errorf("expected the deleted event to be created again, got %d events with count %d", n, count)
+	}
+
+	// events of a nil recorder are ignored
+	var nilRecorder *EventRecorder
+	nilRecorder.Eventf(pod, v1.EventTypeNormal, reasonClaimBound, "bound")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// explainPath serves the feasibility of a pod on the nodes, e.g. /debug/explain?namespace=default&pod=gpu-pod
+const explainPath = "/debug/explain"
+
+// explanation is the feasibility of a pod on every node evaluated
+type explanation struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	// Error is set if the pod can not be evaluated on any node, e.g. a claim is not found
+	Error string            `json:"error,omitempty"`
+	Nodes []nodeExplanation `json:"nodes,omitempty"`
+}
+
+// nodeExplanation is the feasibility of the pod on a node, the same as filter would decide
+type nodeExplanation struct {
+	Node     string `json:"node"`
+	Feasible bool   `json:"feasible"`
+	// Failure is why filter would reject the node
+	Failure *FailureReason `json:"failure,omitempty"`
+	Message string         `json:"message,omitempty"`
+	// Plan is the allocation filter would choose on the node
+	Plan   allocationPlan     `json:"plan,omitempty"`
+	Claims []claimExplanation `json:"claims"`
+}
+
+// claimExplanation lists the extended resources allocatable on the node as candidates of a claim
+type claimExplanation struct {
+	Claim string `json:"claim"`
+	// Needs is the number of extended resources the scheduler selects besides the named ones
+	Needs      int                    `json:"needs"`
+	Named      []string               `json:"named,omitempty"`
+	Candidates []candidateExplanation `json:"candidates"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// candidateExplanation tells whether an extended resource can be allocated to a claim,
+// and the first requirement which rejects it if not
+type candidateExplanation struct {
+	ExtendedResource string                         `json:"extendedResource"`
+	Phase            v1alpha1.ExtendedResourcePhase `json:"phase,omitempty"`
+	Selected         bool                           `json:"selected"`
+	Rejected         FailureKind                    `json:"rejected,omitempty"`
+	Detail           string                         `json:"detail,omitempty"`
+}
+
+// Explain answers why a pod can or can not be scheduled on the nodes, it evaluates the pod the same as filter
+// but neither reserves nor writes anything. The nodes are given as a comma separated list,
+// all nodes are evaluated if none is given.
+func Explain(extendedResourceScheduler *ExtendedResourceScheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		cache := extendedResourceScheduler.Cache
+		if cache != nil && !cache.HasSynced() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("resource cache has not synced"))
+			return
+		}
+		query := r.URL.Query()
+		namespace, name := query.Get("namespace"), query.Get("pod")
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		if name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("pod must be given"))
+			return
+		}
+		pod, err := extendedResourceScheduler.FindPod(name, namespace)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		var nodes []v1.Node
+		if list := query.Get("nodes"); list != "" {
+			for _, nodeName := range strings.Split(list, ",") {
+				node, err := extendedResourceScheduler.FindNode(nodeName)
+				if err != nil {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(err.Error()))
+					return
+				}
+				nodes = append(nodes, *node)
+			}
+		} else {
+			var list []*v1.Node
+			if cache != nil {
+				list = cache.ListNodes()
+			} else if list, err = extendedResourceScheduler.Storage.ListNodes(); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			for _, node := range list {
+				nodes = append(nodes, *node)
+			}
+			sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+		}
+
+		body, err := json.MarshalIndent(explain(pod, nodes, extendedResourceScheduler), "", "  ")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+// explain evaluates pod on nodes
+func explain(pod *v1.Pod, nodes []v1.Node, extendedResourceScheduler *ExtendedResourceScheduler) *explanation {
+	result := &explanation{Namespace: pod.Namespace, Pod: pod.Name}
+	extendedResourceClaims, err := extendedResourceScheduler.FindExtendedResourceClaimList(*pod)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, node := range nodes {
+		result.Nodes = append(result.Nodes, explainNode(pod, extendedResourceClaims, node, extendedResourceScheduler))
+	}
+	return result
+}
+
+// explainNode evaluates pod on node, the candidates of every claim are the extended resources allocatable on node
+func explainNode(pod *v1.Pod, extendedResourceClaims []*v1alpha1.ExtendedResourceClaim, node v1.Node, extendedResourceScheduler *ExtendedResourceScheduler) nodeExplanation {
+	plan, reason := allocateExtendedResources(pod.UID, extendedResourceClaims, node, extendedResourceScheduler)
+	result := nodeExplanation{Node: node.Name, Feasible: reason == nil, Failure: reason, Plan: plan}
+	if reason != nil {
+		result.Message = reason.String()
+	}
+
+	extendedResources := make([]*v1alpha1.ExtendedResource, 0, len(node.Status.ExtendedResourceAllocatable))
+	for _, erName := range node.Status.ExtendedResourceAllocatable {
+		if er, err := extendedResourceScheduler.FindExtendedResource(erName); err == nil {
+			extendedResources = append(extendedResources, er)
+		}
+	}
+
+	for _, erc := range extendedResourceClaims {
+		claim := claimExplanation{
+			Claim:      erc.Name,
+			Named:      erc.Spec.ExtendedResourceNames,
+			Candidates: make([]candidateExplanation, 0, len(extendedResources)),
+		}
+		if needs := erc.Spec.ExtendedResourceNum - int64(len(erc.Spec.ExtendedResourceNames)); needs > 0 {
+			claim.Needs = int(needs)
+		}
+		selector, err := newPropertySelector(erc.Spec.MetadataRequirements)
+		if err != nil {
+			claim.Error = err.Error()
+			result.Claims = append(result.Claims, claim)
+			continue
+		}
+		requirements := claimRequirements(pod.UID, erc, selector, &node, extendedResourceScheduler)
+		for _, erName := range erc.Spec.ExtendedResourceNames {
+			if !containsString(node.Status.ExtendedResourceAllocatable, erName) {
+				claim.Candidates = append(claim.Candidates, candidateExplanation{
+					ExtendedResource: erName,
+					Rejected:         FailureUnavailable,
+					Detail:           "not on node",
+				})
+			}
+		}
+		for _, er := range extendedResources {
+			candidate := candidateExplanation{
+				ExtendedResource: er.Name,
+				Phase:            er.Status.Phase,
+				Selected:         containsString(plan[erc.Name], er.Name),
+			}
+			named := containsString(erc.Spec.ExtendedResourceNames, er.Name)
+			if other := claimOf(extendedResourceClaims, er.Name); other != "" && !named {
+				candidate.Rejected = FailureUnavailable
+				candidate.Detail = fmt.Sprintf("named by %s", other)
+				claim.Candidates = append(claim.Candidates, candidate)
+				continue
+			}
+			for _, requirement := range requirements {
+				if named && !requirement.named {
+					continue
+				}
+				if !requirement.matches(er) {
+					candidate.Rejected = requirement.kind
+					if requirement.kind == FailureUnavailable {
+						candidate.Detail = phaseDetail(er.Status.Phase)
+					}
+					break
+				}
+			}
+			claim.Candidates = append(claim.Candidates, candidate)
+		}
+		result.Claims = append(result.Claims, claim)
+	}
+	return result
+}
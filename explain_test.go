@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/api/extensions/v1alpha1"
+)
+
+func TestExplain(t *testing.T) {
+	k80 := map[string]string{"model": "k80"}
+	p100 := map[string]string{"model": "p100"}
+	cache := newTestCache(
+		newTestER("er1", k80),
+		withPhase(newTestER("er2", k80), v1alpha1.ExtendedResourceBound),
+		newTestER("er3", p100),
+		newTestER("er4", k80),
+		newTestNode("node1", "er1", "er2"),
+		newTestNode("node2", "er3", "er4"),
+		newTestClaim("erc1", 1, k80),
+		newTestPod("pod1", "erc1"),
+	)
+	reservations := NewReservationCache(time.Minute)
+	if err := reservations.Confirm("pod2", "node1", allocationPlan{"erc2": {"er1"}}); err != nil {
+		t.Fatalf("confirm failed: %v", err)
+	}
+	extendedResourceScheduler := &ExtendedResourceScheduler{Cache: cache, Reservations: reservations}
+
+	recorder := httptest.NewRecorder()
+	Explain(extendedResourceScheduler).ServeHTTP(recorder, httptest.NewRequest("GET", explainPath+"?pod=pod1", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	var result explanation
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("unmarshal explanation failed: %v", err)
+	}
+	if len(result.Nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(result.Nodes))
+	}
+
+	// node1: er1 is reserved by pod2 and er2 is bound
+	node1 := result.Nodes[0]
+	if node1.Node != "node1" || node1.Feasible || node1.Failure == nil || node1.Failure.Kind != FailureReserved {
+		t.Errorf("expected node1 to be infeasible with %s, got %+v", FailureReserved, node1)
+	}
+	expected := []candidateExplanation{
+		{ExtendedResource: "er1", Phase: v1alpha1.ExtendedResourceAvailable, Rejected: FailureReserved},
+		{ExtendedResource: "er2", Phase: v1alpha1.ExtendedResourceBound, Rejected: FailureUnavailable, Detail: "bound"},
+	}
+	if !reflect.DeepEqual(node1.Claims[0].Candidates, expected) {
+		t.Errorf("expected candidates on node1 %+v, got %+v", expected, node1.Claims[0].Candidates)
+	}
+
+	// node2: er3 does not match the properties, er4 is selected
+	node2 := result.Nodes[1]
+	if !node2.Feasible || !reflect.DeepEqual(node2.Plan, allocationPlan{"erc1": {"er4"}}) {
+		t.Errorf("expected node2 to be feasible with er4, got %+v", node2)
+	}
+	expected = []candidateExplanation{
+		{ExtendedResource: "er3", Phase: v1alpha1.ExtendedResourceAvailable, Rejected: FailurePropertyMismatch},
+		{ExtendedResource: "er4", Phase: v1alpha1.ExtendedResourceAvailable, Selected: true},
+	}
+	if !reflect.DeepEqual(node2.Claims[0].Candidates, expected) {
+		t.Errorf("expected candidates on node2 %+v, got %+v", expected, node2.Claims[0].Candidates)
+	}
+
+	// explain must not reserve anything for the pod
+	if _, ok := reservations.Plan("pod1", "node2"); ok {
+		t.Errorf("expected explain not to reserve extended resources")
+	}
+
+	for _, test := range []struct {
+		name     string
+		url      string
+		expected int
+	}{
+		{name: "pod not given", url: explainPath, expected: http.StatusBadRequest},
+		{name: "pod not found", url: explainPath + "?namespace=other&pod=pod1", expected: http.StatusNotFound},
+		{name: "node not found", url: explainPath + "?pod=pod1&nodes=node1,node3", expected: http.StatusNotFound},
+	} {
+		recorder := httptest.NewRecorder()
+		Explain(extendedResourceScheduler).ServeHTTP(recorder, httptest.NewRequest("GET", test.url, nil))
+		if recorder.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expected, recorder.Code)
+		}
+	}
+}
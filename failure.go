@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FailureKind classifies why a node can not satisfy the extendedresourceclaims of a pod
+type FailureKind string
+
+const (
+	// FailureNotEnough means there are fewer extended resources than the claims need,
+	// e.g. the matching ones are shared by several claims of the pod
+	FailureNotEnough FailureKind = "NotEnoughExtendedResources"
+	// FailureUnavailable means an extended resource named by a claim is not allocatable on the node or not Available
+	FailureUnavailable FailureKind = "ExtendedResourceUnavailable"
+	// FailurePropertyMismatch means too few extended resources have the properties a claim requires
+	FailurePropertyMismatch FailureKind = "PropertyMismatch"
+	// FailureRawResourceMismatch means too few extended resources have the raw resource name of a claim
+	FailureRawResourceMismatch FailureKind = "RawResourceMismatch"
+	// FailureAffinityMismatch means the node does not match the node affinity of the extended resources
+	FailureAffinityMismatch FailureKind = "AffinityMismatch"
+	// FailureReserved means the extended resources are reserved for another pod being scheduled
+	FailureReserved FailureKind = "ReservedByOtherPod"
+	// FailureInvalidClaim means a claim can not be evaluated, e.g. its metadata requirements are invalid
+	FailureInvalidClaim FailureKind = "InvalidClaim"
+	// FailureNodeNotFound means a node sent by kube-scheduler is not in the cache
+	FailureNodeNotFound FailureKind = "NodeNotFound"
+	// FailureError means the state needed to evaluate the node could not be read
+	FailureError FailureKind = "Error"
+)
+
+// FailureReason is why a node can not satisfy a pod, with the claim and the extended resources involved
+type FailureReason struct {
+	Kind FailureKind `json:"kind"`
+	// Claim is the extendedresourceclaim which can not be satisfied, empty if the failure is about the pod as a whole
+	Claim string `json:"claim,omitempty"`
+	// ExtendedResources are the extended resources which caused the failure
+	ExtendedResources []string `json:"extendedResources,omitempty"`
+	// Detail completes the message, e.g. the phase of an unavailable extended resource or an error
+	Detail string `json:"detail,omitempty"`
+}
+
+// String returns the message of the reason, it is sent to kube-scheduler as the failure of the node
+func (r *FailureReason) String() string {
+	ers := strings.Join(r.ExtendedResources, " ")
+	switch r.Kind {
+	case FailureNotEnough:
+		if r.Claim == "" {
+			return "fewer extended resources on node than the pod claims"
+		}
+		return fmt.Sprintf("not enough extended resources for %s", r.Claim)
+	case FailureUnavailable:
+		return fmt.Sprintf("%s %s", ers, r.Detail)
+	case FailurePropertyMismatch:
+		return fmt.Sprintf("not enough extended resources matching %s", r.Claim)
+	case FailureRawResourceMismatch:
+		return fmt.Sprintf("not enough extended resources of %s for %s", r.Detail, r.Claim)
+	case FailureAffinityMismatch:
+		if r.Claim == "" {
+			return fmt.Sprintf("node affinity of %s not matched", ers)
+		}
+		return fmt.Sprintf("node affinity of %s not matched for %s", ers, r.Claim)
+	case FailureReserved:
+		return fmt.Sprintf("%s reserved by another pod", ers)
+	case FailureInvalidClaim:
+		return fmt.Sprintf("invalid %s: %s", r.Claim, r.Detail)
+	case FailureNodeNotFound:
+		return fmt.Sprintf("node not found: %s", r.Detail)
+	default:
+		return r.Detail
+	}
+}
+
+// failureMessages converts the reasons to the failed nodes sent to kube-scheduler
+func failureMessages(reasons map[string]*FailureReason) map[string]string {
+	messages := make(map[string]string, len(reasons))
+	for node, reason := range reasons {
+		messages[node] = reason.String()
+	}
+	return messages
+}
+
+// summarizeFailures aggregates the nodes by reason, the most common reasons first, e.g.
+// "0/5 nodes are available: 3 nodes: not enough extended resources matching erc1; 2 nodes: er5 bound"
+func summarizeFailures(reasons map[string]*FailureReason) string {
+	if len(reasons) == 0 {
+		return "no nodes were given to filter"
+	}
+	counts := make(map[string]int)
+	for _, reason := range reasons {
+		counts[reason.String()]++
+	}
+	messages := make([]string, 0, len(counts))
+	for message := range counts {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if counts[messages[i]] != counts[messages[j]] {
+			return counts[messages[i]] > counts[messages[j]]
+		}
+		return messages[i] < messages[j]
+	})
+	parts := make([]string, 0, len(messages))
+	for _, message := range messages {
+		unit := "nodes"
+		if counts[message] == 1 {
+			unit = "node"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s: %s", counts[message], unit, message))
+	}
+	return fmt.Sprintf("0/%d nodes are available: %s", len(reasons), strings.Join(parts, "; "))
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestSummarizeFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		reasons  map[string]*FailureReason
+		expected string
+	}{
+		{
+			name: "most common reason first",
+			reasons: map[string]*FailureReason{
+				"node1": {Kind: FailureUnavailable, Claim: "erc1", ExtendedResources: []string{"er5"}, Detail: "bound"},
+				"node2": {Kind: FailurePropertyMismatch, Claim: "erc1"},
+				"node3": {Kind: FailurePropertyMismatch, Claim: "erc1"},
+			},
+			expected: "0/3 nodes are available: 2 nodes: not enough extended resources matching erc1; 1 node: er5 bound",
+		},
+		{
+			name: "same count sorted by message",
+			reasons: map[string]*FailureReason{
+				"node1": {Kind: FailureReserved, ExtendedResources: []string{"er1", "er2"}},
+				"node2": {Kind: FailureNodeNotFound, Detail: `node "node2" not found`},
+			},
+			expected: `0/2 nodes are available: 1 node: er1 er2 reserved by another pod; 1 node: node not found: node "node2" not found`,
+		},
+		{
+			name: "claims are told apart",
+			reasons: map[string]*FailureReason{
+				"node1": {Kind: FailureNotEnough, Claim: "erc1"},
+				"node2": {Kind: FailureNotEnough, Claim: "erc2"},
+				"node3": {Kind: FailureNotEnough},
+			},
+			expected: "0/3 nodes are available: 1 node: fewer extended resources on node than the pod claims; " +
+				"1 node: not enough extended resources for erc1; 1 node: not enough extended resources for erc2",
+		},
+		{
+			name:     "no nodes",
+			reasons:  map[string]*FailureReason{},
+			expected: "no nodes were given to filter",
+		},
+	}
+	for _, test := range tests {
+		if got := summarizeFailures(test.reasons); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// testRawResourceName is the raw resource name of the extended resources and claims built by the helpers below
+const testRawResourceName = "nvidia.com/gpu"
+
+// newTestER returns an available extended resource with properties
+func newTestER(name string, properties map[string]string) *v1alpha1.ExtendedResource {
+	return &v1alpha1.ExtendedResource{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1alpha1.ExtendedResourceSpec{RawResourceName: testRawResourceName, Properties: properties},
+		Status:     v1alpha1.ExtendedResourceStatus{Phase: v1alpha1.ExtendedResourceAvailable},
+	}
+}
+
+// withPhase sets the phase of er and returns it
+func withPhase(er *v1alpha1.ExtendedResource, phase v1alpha1.ExtendedResourcePhase) *v1alpha1.ExtendedResource {
+	er.Status.Phase = phase
+	return er
+}
+
+// boundTo marks er bound to the claim ercName and returns it
+func boundTo(er *v1alpha1.ExtendedResource, ercName string) *v1alpha1.ExtendedResource {
+	er.Spec.ExtendedResourceClaimName = ercName
+	return withPhase(er, v1alpha1.ExtendedResourceBound)
+}
+
+// newTestNode returns a node with erNames allocatable
+func newTestNode(name string, erNames ...string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{ExtendedResourceAllocatable: erNames},
+	}
+}
+
+// newTestClaim returns a claim in the default namespace for num extended resources matching matchLabels
+func newTestClaim(name string, num int64, matchLabels map[string]string) *v1alpha1.ExtendedResourceClaim {
+	return &v1alpha1.ExtendedResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: name},
+		Spec: v1alpha1.ExtendedResourceClaimSpec{
+			RawResourceName:      testRawResourceName,
+			ExtendedResourceNum:  num,
+			MetadataRequirements: metav1.LabelSelector{MatchLabels: matchLabels},
+		},
+	}
+}
+
+// newTestPod returns a pod in the default namespace using the claims ercNames, its uid is its name
+func newTestPod(name string, ercNames ...string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: name, UID: types.UID(name)},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "c", ExtendedResourceClaims: ercNames}}},
+	}
+}
+
+// newTestCache returns a synced cache holding objs
+func newTestCache(objs ...runtime.Object) *ResourceCache {
+	cache := NewResourceCache(&kubernetes.Clientset{})
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *v1alpha1.ExtendedResource:
+			cache.extendedResources.store.add(obj)
+		case *v1alpha1.ExtendedResourceClaim:
+			cache.extendedResourceClaims.store.add(obj)
+		case *v1.Node:
+			cache.nodes.store.add(obj)
+		case *v1.Pod:
+			cache.pods.store.add(obj)
+		}
+	}
+	for _, r := range cache.reflectors() {
+		r.synced = true
+	}
+	return cache
+}
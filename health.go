@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+	metricsPath = "/metrics"
+)
+
+// apiServerCheckTimeout bounds the request made to the apiserver by a readiness check
+const apiServerCheckTimeout = 5 * time.Second
+
+// HealthChecker answers the liveness and readiness probes of the scheduler
+type HealthChecker struct {
+	// Clientset is checked for reaching the apiserver if it is set
+	Clientset *kubernetes.Clientset
+	// Cache is checked for sync once it is set
+	Cache *ResourceCache
+
+	// shuttingDown is set to 1 once shutdown starts
+	shuttingDown int32
+}
+
+// ShuttingDown makes the scheduler unready, so no new requests are routed to it while it drains
+func (h *HealthChecker) ShuttingDown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+// Healthz reports the process is alive and serving
+func Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+// Readyz reports whether the scheduler can serve the extender verbs,
+// i.e. the apiserver is reachable and the resource cache has synced, if the scheduler uses them
+func Readyz(checker *HealthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failures := checker.check()
+		if len(failures) > 0 {
+			glog.V(2).Infof("readiness check failed: %s", strings.Join(failures, "; "))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(strings.Join(failures, "\n")))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+// Synced returns true once the resource cache has synced, so the verbs can be served from it
+func (h *HealthChecker) Synced() bool {
+	return h.Cache == nil || h.Cache.HasSynced()
+}
+
+// check returns the reasons why the scheduler is not ready
+func (h *HealthChecker) check() []string {
+	failures := make([]string, 0)
+	if atomic.LoadInt32(&h.shuttingDown) == 1 {
+		failures = append(failures, "scheduler is shutting down")
+	}
+	if h.Clientset != nil {
+		if err := h.checkAPIServer(); err != nil {
+			failures = append(failures, fmt.Sprintf("apiserver is not reachable: %v", err))
+		}
+	}
+	if !h.Synced() {
+		failures = append(failures, "resource cache has not synced")
+	}
+	return failures
+}
+
+func (h *HealthChecker) checkAPIServer() error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiServerCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err := h.Clientset.Discovery().RESTClient().Get().AbsPath("/healthz").Context(ctx).Do().Raw()
+	observeAPIRequest("get", "healthz", start)
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestReadyz(t *testing.T) {
+	apiServerStatus := http.StatusOK
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(apiServerStatus)
+	}))
+	defer apiServer.Close()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		apiServerStatus int
+		cache           *ResourceCache
+		expected        int
+	}{
+		{
+			name:            "apiserver reachable, no cache yet",
+			apiServerStatus: http.StatusOK,
+			expected:        http.StatusOK,
+		},
+		{
+			name:            "apiserver unhealthy",
+			apiServerStatus: http.StatusInternalServerError,
+			expected:        http.StatusServiceUnavailable,
+		},
+		{
+			name:            "cache not synced",
+			apiServerStatus: http.StatusOK,
+			cache:           NewResourceCache(clientset),
+			expected:        http.StatusServiceUnavailable,
+		},
+	}
+	for _, test := range tests {
+		apiServerStatus = test.apiServerStatus
+		recorder := httptest.NewRecorder()
+		Readyz(&HealthChecker{Clientset: clientset, Cache: test.cache})(recorder, httptest.NewRequest("GET", readyzPath, nil))
+		if recorder.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", test.name, test.expected, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestSchedulerHandler(t *testing.T) {
+	synced := false
+	mux = map[string]func(http.ResponseWriter, *http.Request){
+		"/scheduler/bind": func(w http.ResponseWriter, r *http.Request) {},
+	}
+	handler := &SchedulerHandler{
+		Endpoints: map[string]http.HandlerFunc{healthzPath: Healthz()},
+		Synced:    func() bool { return synced },
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		synced   bool
+		expected int
+	}{
+		{name: "healthz before sync", path: healthzPath, expected: http.StatusOK},
+		{name: "verb before sync", path: "/scheduler/bind", expected: http.StatusServiceUnavailable},
+		{name: "verb after sync", path: "/scheduler/bind", synced: true, expected: http.StatusOK},
+		{name: "unknown path", path: "/scheduler/unknown", synced: true, expected: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		synced = test.synced
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", test.path, nil))
+		if recorder.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expected, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// leaderAnnotation is the annotation of the lock configmap holding the leader election record,
+// the same as client-go leader election so existing tooling can read it
+const leaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+// leaderElectionRecord is the record stored in the lock, compatible with client-go
+type leaderElectionRecord struct {
+	HolderIdentity       string      `json:"holderIdentity"`
+	LeaseDurationSeconds int         `json:"leaseDurationSeconds"`
+	AcquireTime          metav1.Time `json:"acquireTime"`
+	RenewTime            metav1.Time `json:"renewTime"`
+	LeaderTransitions    int         `json:"leaderTransitions"`
+}
+
+// LeaderElector elects one leader among the scheduler replicas using a configmap as the lock.
+// The vendored client-go has no leader election package, so this follows its algorithm:
+// a replica takes the lock when the lease of the holder has not been renewed within the lease duration,
+// as observed by its own clock, and the leader gives up when it can not renew within the renew deadline.
+type LeaderElector struct {
+	config     LeaderElectionConfig
+	configMaps corev1client.ConfigMapInterface
+	identity   string
+
+	// OnStartedLeading is called when the replica becomes the leader, stopCh is closed when it stops leading
+	OnStartedLeading func(stopCh <-chan struct{})
+
+	lock   sync.RWMutex
+	leader string
+	// leaderStopCh is open while this replica leads
+	leaderStopCh chan struct{}
+	lastRenew    time.Time
+	// observedRecord is the raw record last read from the lock, and observedTime when it was seen to change
+	observedRecord string
+	observedTime   time.Time
+
+	now func() time.Time
+}
+
+// NewLeaderElector creates a LeaderElector taking the lock in configMaps
+func NewLeaderElector(config LeaderElectionConfig, configMaps corev1client.ConfigMapInterface) *LeaderElector {
+	hostname, _ := os.Hostname()
+	return &LeaderElector{
+		config:     config,
+		configMaps: configMaps,
+		identity:   hostname + "_" + rand.String(8),
+		now:        time.Now,
+	}
+}
+
+// IsLeader returns whether this replica is the leader
+func (le *LeaderElector) IsLeader() bool {
+	le.lock.RLock()
+	defer le.lock.RUnlock()
+	return le.leaderStopCh != nil
+}
+
+// Leader returns the identity of the leader last observed
+func (le *LeaderElector) Leader() string {
+	le.lock.RLock()
+	defer le.lock.RUnlock()
+	return le.leader
+}
+
+// Run tries to acquire or renew the lock every retry period until stopCh is closed,
+// the lock is released on stop so another replica can take over at once
+func (le *LeaderElector) Run(stopCh <-chan struct{}) {
+	glog.Infof("leader election of %s is starting, lock %s/%s", le.identity, le.config.LockNamespace, le.config.LockName)
+	wait.JitterUntil(le.tryAcquireOrRenew, le.config.RetryPeriod.Duration, 0.2, true, stopCh)
+	le.release()
+}
+
+func (le *LeaderElector) tryAcquireOrRenew() {
+	now := le.now()
+	// a leader must renew before its renew deadline, so its attempt gives up then,
+	// other replicas give each attempt the whole renew deadline
+	timeout := le.config.RenewDeadline.Duration
+	le.lock.RLock()
+	if le.leaderStopCh != nil {
+		timeout = le.lastRenew.Add(timeout).Sub(now)
+	}
+	le.lock.RUnlock()
+	err := le.updateLockWithin(now, timeout)
+
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	_, timedOut := err.(*lockTimeoutError)
+	switch {
+	case err == nil:
+		le.lastRenew = now
+		if le.leaderStopCh == nil {
+			glog.Infof("%s became the leader", le.identity)
+			le.leaderStopCh = make(chan struct{})
+			if le.OnStartedLeading != nil {
+				go le.OnStartedLeading(le.leaderStopCh)
+			}
+		}
+	case le.leaderStopCh != nil && (timedOut || now.Sub(le.lastRenew) > le.config.RenewDeadline.Duration):
+		glog.Errorf("%s stopped leading, the lease was not renewed within %v: %v", le.identity, le.config.RenewDeadline.Duration, err)
+		close(le.leaderStopCh)
+		le.leaderStopCh = nil
+	default:
+		glog.V(4).Infof("lock is not acquired: %v", err)
+	}
+}
+
+// lockTimeoutError is returned by updateLockWithin when the lock is not updated in time
+type lockTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *lockTimeoutError) Error() string {
+	return fmt.Sprintf("lock was not updated within %v", e.timeout)
+}
+
+// updateLockWithin runs updateLock but gives up after timeout. The vendored client-go takes no context,
+// so a request still hanging then is left to finish in the background and its result is ignored.
+func (le *LeaderElector) updateLockWithin(now time.Time, timeout time.Duration) error {
+	if timeout <= 0 {
+		return &lockTimeoutError{timeout: timeout}
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- le.updateLock(now)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		return &lockTimeoutError{timeout: timeout}
+	}
+}
+
+// updateLock takes or renews the lock, it returns an error if the lock is held by another replica or can not be written
+func (le *LeaderElector) updateLock(now time.Time) error {
+	record := leaderElectionRecord{
+		HolderIdentity:       le.identity,
+		LeaseDurationSeconds: int(le.config.LeaseDuration.Duration / time.Second),
+		AcquireTime:          metav1.NewTime(now),
+		RenewTime:            metav1.NewTime(now),
+	}
+
+	cm, err := le.configMaps.Get(le.config.LockName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: le.config.LockNamespace, Name: le.config.LockName}}
+		if err := setLeaderRecord(cm, record); err != nil {
+			return err
+		}
+		if _, err := le.configMaps.Create(cm); err != nil {
+			return err
+		}
+		le.observe(cm.Annotations[leaderAnnotation], record.HolderIdentity, now)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var current leaderElectionRecord
+	data, ok := cm.Annotations[leaderAnnotation]
+	if ok {
+		if err := json.Unmarshal([]byte(data), &current); err != nil {
+			return fmt.Errorf("parse leader election record: %v", err)
+		}
+	}
+	observedTime := le.observe(data, current.HolderIdentity, now)
+	leaseDuration := time.Duration(current.LeaseDurationSeconds) * time.Second
+	if current.HolderIdentity != "" && current.HolderIdentity != le.identity && observedTime.Add(leaseDuration).After(now) {
+		return fmt.Errorf("lock is held by %s", current.HolderIdentity)
+	}
+
+	if current.HolderIdentity == le.identity {
+		record.AcquireTime = current.AcquireTime
+		record.LeaderTransitions = current.LeaderTransitions
+	} else {
+		record.LeaderTransitions = current.LeaderTransitions + 1
+	}
+	if err := setLeaderRecord(cm, record); err != nil {
+		return err
+	}
+	// the update fails on conflict if another replica wrote the lock since it was read
+	if _, err := le.configMaps.Update(cm); err != nil {
+		return err
+	}
+	le.observe(cm.Annotations[leaderAnnotation], record.HolderIdentity, now)
+	return nil
+}
+
+// observe remembers when the record in the lock was seen to change and returns that time,
+// the lease of another holder is measured from it so the clocks of the replicas need not agree
+func (le *LeaderElector) observe(record, holder string, now time.Time) time.Time {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	if record != le.observedRecord {
+		le.observedRecord = record
+		le.observedTime = now
+	}
+	le.leader = holder
+	return le.observedTime
+}
+
+// release gives up the lock if this replica holds it
+func (le *LeaderElector) release() {
+	le.lock.Lock()
+	if le.leaderStopCh != nil {
+		close(le.leaderStopCh)
+		le.leaderStopCh = nil
+	}
+	le.lock.Unlock()
+
+	cm, err := le.configMaps.Get(le.config.LockName, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+	var current leaderElectionRecord
+	if err := json.Unmarshal([]byte(cm.Annotations[leaderAnnotation]), &current); err != nil || current.HolderIdentity != le.identity {
+		return
+	}
+	now := metav1.NewTime(le.now())
+	record := leaderElectionRecord{
+		LeaseDurationSeconds: 1,
+		AcquireTime:          now,
+		RenewTime:            now,
+		LeaderTransitions:    current.LeaderTransitions,
+	}
+	if err := setLeaderRecord(cm, record); err != nil {
+		return
+	}
+	if _, err := le.configMaps.Update(cm); err != nil {
+		glog.Errorf("release leader election lock failed: %v", err)
+		return
+	}
+	glog.Infof("%s released the leader election lock", le.identity)
+}
+
+func setLeaderRecord(cm *v1.ConfigMap, record leaderElectionRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = make(map[string]string)
+	}
+	cm.Annotations[leaderAnnotation] = string(data)
+	return nil
+}
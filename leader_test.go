@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// fakeConfigMaps keeps configmaps in memory and rejects updates of stale versions,
+// the methods not used by leader election are left unimplemented
+type fakeConfigMaps struct {
+	corev1client.ConfigMapInterface
+	items map[string]*v1.ConfigMap
+	// hang blocks every Get until it is closed, if it is set
+	hang chan struct{}
+}
+
+func (f *fakeConfigMaps) Get(name string, options metav1.GetOptions) (*v1.ConfigMap, error) {
+	if f.hang != nil {
+		<-f.hang
+	}
+	cm, ok := f.items[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+	}
+	return cm.DeepCopy(), nil
+}
+
+func (f *fakeConfigMaps) Create(cm *v1.ConfigMap) (*v1.ConfigMap, error) {
+	if _, ok := f.items[cm.Name]; ok {
+		return nil, apierrors.NewAlreadyExists(schema.GroupResource{Resource: "configmaps"}, cm.Name)
+	}
+	cm = cm.DeepCopy()
+	cm.ResourceVersion = "1"
+	f.items[cm.Name] = cm
+	return cm.DeepCopy(), nil
+}
+
+func (f *fakeConfigMaps) Update(cm *v1.ConfigMap) (*v1.ConfigMap, error) {
+	current, ok := f.items[cm.Name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, cm.Name)
+	}
+	if current.ResourceVersion != cm.ResourceVersion {
+		return nil, apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, cm.Name, nil)
+	}
+	version, _ := strconv.Atoi(current.ResourceVersion)
+	cm = cm.DeepCopy()
+	cm.ResourceVersion = strconv.Itoa(version + 1)
+	f.items[cm.Name] = cm
+	return cm.DeepCopy(), nil
+}
+
+func TestLeaderElector(t *testing.T) {
+	config := NewDefaultConfig().LeaderElection
+	configMaps := &fakeConfigMaps{items: make(map[string]*v1.ConfigMap)}
+	now := time.Now()
+	newElector := func(identity string) *LeaderElector {
+		le := NewLeaderElector(config, configMaps)
+		le.identity = identity
+		le.now = func() time.Time { return now }
+		return le
+	}
+	a, b := newElector("a"), newElector("b")
+	started := make(chan struct{}, 2)
+	b.OnStartedLeading = func(stopCh <-chan struct{}) { started <- struct{}{} }
+
+	a.tryAcquireOrRenew()
+	b.tryAcquireOrRenew()
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected a to lead after taking the lock first")
+	}
+	if b.Leader() != "a" {
+		t.Errorf("expected b to observe leader a, got %q", b.Leader())
+	}
+	followerScheduler := &ExtendedResourceScheduler{Leader: b}
+	if err := followerScheduler.checkLeader(); err == nil {
+		t.Errorf("expected a follower to refuse writes")
+	}
+
+	// a renews within the lease, b keeps following
+	now = now.Add(config.RetryPeriod.Duration)
+	a.tryAcquireOrRenew()
+	now = now.Add(config.LeaseDuration.Duration - time.Second)
+	b.tryAcquireOrRenew()
+	if b.IsLeader() {
+		t.Errorf("expected b to follow while the lease of a is renewed")
+	}
+
+	// a stops renewing, b takes over once the lease expires as observed by b,
+	// and a stops leading after the renew deadline
+	now = now.Add(config.LeaseDuration.Duration - time.Second)
+	b.tryAcquireOrRenew()
+	if b.IsLeader() {
+		t.Errorf("expected b to follow until the lease observed by it expires")
+	}
+	now = now.Add(2 * time.Second)
+	b.tryAcquireOrRenew()
+	if !b.IsLeader() {
+		t.Fatalf("expected b to lead after the lease of a expired")
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Errorf("expected OnStartedLeading to be called")
+	}
+	a.tryAcquireOrRenew()
+	if a.IsLeader() {
+		t.Errorf("expected a to stop leading after the renew deadline")
+	}
+	if err := followerScheduler.checkLeader(); err != nil {
+		t.Errorf("expected the leader to accept writes, got %v", err)
+	}
+
+	// b releases the lock on stop, a takes it at once
+	b.release()
+	if b.IsLeader() {
+		t.Errorf("expected b to stop leading after release")
+	}
+	now = now.Add(config.RetryPeriod.Duration)
+	a.tryAcquireOrRenew()
+	if !a.IsLeader() {
+		t.Errorf("expected a to lead after b released the lock")
+	}
+}
+
+func TestLeaderElectorLockTimeout(t *testing.T) {
+	config := NewDefaultConfig().LeaderElection
+	config.RenewDeadline.Duration = 100 * time.Millisecond
+	configMaps := &fakeConfigMaps{items: make(map[string]*v1.ConfigMap)}
+	le := NewLeaderElector(config, configMaps)
+	stopped := make(chan struct{})
+	le.OnStartedLeading = func(stopCh <-chan struct{}) {
+		<-stopCh
+		close(stopped)
+	}
+	le.tryAcquireOrRenew()
+	if !le.IsLeader() {
+		t.Fatalf("expected to lead after taking the lock")
+	}
+
+	// the apiserver stops answering, the leader gives up at its renew deadline instead of waiting for it
+	configMaps.hang = make(chan struct{})
+	defer close(configMaps.hang)
+	done := make(chan struct{})
+	go func() {
+		le.tryAcquireOrRenew()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the attempt to give up after the renew deadline")
+	}
+	if le.IsLeader() {
+		t.Errorf("expected to stop leading when the lock can not be renewed in time")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("expected the leading stop channel to be closed")
+	}
+}
@@ -5,24 +5,49 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
-)
-
-const (
-	addr = ":8089"
+	"k8s.io/client-go/kubernetes"
 )
 
 var mux map[string]func(http.ResponseWriter, *http.Request)
 
 // SchedulerHandler implements custom handler
-type SchedulerHandler struct{}
+type SchedulerHandler struct {
+	// Endpoints are served outside the extender verbs, e.g. health probes and metrics
+	Endpoints map[string]http.HandlerFunc
+	// Synced gates the extender verbs, they are refused until it returns true
+	Synced func() bool
+	// RequireClientCert refuses the extender verbs unless the client presented a verified certificate
+	RequireClientCert bool
+
+	// inFlight counts the extender verbs being served, they are waited for on shutdown
+	inFlight sync.WaitGroup
+}
 
-func (*SchedulerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (s *SchedulerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := s.Endpoints[r.URL.Path]; ok {
+		h(w, r)
+		return
+	}
 	glog.V(2).Infof("request url: %s", r.URL.Path)
 	if h, ok := mux[r.URL.Path]; ok {
+		if s.RequireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "a verified client certificate is required")
+			return
+		}
+		if s.Synced != nil && !s.Synced() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "resource cache has not synced")
+			return
+		}
+		s.inFlight.Add(1)
+		defer s.inFlight.Done()
 		h(w, r)
 		return
 	}
@@ -31,35 +56,249 @@ func (*SchedulerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	var master, kubeConfig *string
-	if home := homeDir(); home != "" {
-		kubeConfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeConfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(runSimulate(os.Args[2:], os.Stdout, os.Stderr))
 	}
-	master = flag.String("master", "http://127.0.0.1:8080", "kubernetes cluster default address")
+
+	configFile := flag.String("config", "", "path to the configuration file, command line flags override its values")
+	kubeConfig := flag.String("kubeconfig", "", "absolute path to the kubeconfig file (default $HOME/.kube/config)")
+	master := flag.String("master", "", "kubernetes cluster address (default http://127.0.0.1:8080)")
+	address := flag.String("address", "", "address the scheduler server listens on (default :8089)")
+	kubeAPIQPS := flag.Float64("kube-api-qps", 0, "QPS to use while talking with kubernetes apiserver (default 5)")
+	kubeAPIBurst := flag.Int("kube-api-burst", 0, "burst to use while talking with kubernetes apiserver (default 10)")
+	priorityStrategy := flag.String("priority-strategy", "", "strategy used to score nodes, binpack or spread (default binpack)")
+	claimResyncPeriod := flag.Duration("claim-resync-period", 0, "period to check all bound extendedresourceclaims for lost extended resources (default 30s)")
+	releaseResyncPeriod := flag.Duration("release-resync-period", 0, "period to check all bound extendedresourceclaims for terminated pods (default 1m)")
+	reservationTTL := flag.Duration("reservation-ttl", 0, "how long the allocation plans computed by filter are kept for bind, and how long bind reserves them (default 30s)")
+	leaderElect := flag.Bool("leader-elect", false, "elect a leader among replicas, only the leader binds and runs the controllers")
+	shutdownGracePeriod := flag.Duration("shutdown-grace-period", 0, "how long requests in flight are waited for on SIGTERM (default 30s)")
+	tlsCertFile := flag.String("tls-cert-file", "", "file containing the x509 certificate for https, plain http is served if not given")
+	tlsPrivateKeyFile := flag.String("tls-private-key-file", "", "file containing the x509 private key matching --tls-cert-file")
+	clientCAFile := flag.String("client-ca-file", "", "if given, clients must present a certificate signed by one of the CAs in the file")
+	storageDir := flag.String("storage-dir", "", "run on the objects of the yaml and json files in the directory instead of the apiserver")
 	flag.Parse()
 
-	clientset, err := CreateClientset(master, kubeConfig)
-	if err != nil {
-		glog.Fatalf("create clientset error: %v", err)
+	config := NewDefaultConfig()
+	if *configFile != "" {
+		var err error
+		if config, err = LoadConfig(*configFile); err != nil {
+			glog.Fatalf("load config failed: %v", err)
+		}
+	}
+	// only the flags given on the command line override the configuration file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "kubeconfig":
+			config.Client.Kubeconfig = *kubeConfig
+		case "master":
+			config.Client.Master = *master
+		case "address":
+			config.Server.Address = *address
+		case "kube-api-qps":
+			config.Client.QPS = float32(*kubeAPIQPS)
+		case "kube-api-burst":
+			config.Client.Burst = *kubeAPIBurst
+		case "priority-strategy":
+			config.PriorityStrategy = *priorityStrategy
+		case "claim-resync-period":
+			config.ClaimResyncPeriod.Duration = *claimResyncPeriod
+		case "release-resync-period":
+			config.ReleaseResyncPeriod.Duration = *releaseResyncPeriod
+		case "reservation-ttl":
+			config.ReservationTTL.Duration = *reservationTTL
+		case "storage-dir":
+			config.Storage.Directory = *storageDir
+		case "leader-elect":
+			config.LeaderElection.LeaderElect = *leaderElect
+		case "shutdown-grace-period":
+			config.Server.ShutdownGracePeriod.Duration = *shutdownGracePeriod
+		case "tls-cert-file", "tls-private-key-file", "client-ca-file":
+			if config.Server.TLS == nil {
+				config.Server.TLS = &TLSConfig{}
+			}
+			switch f.Name {
+			case "tls-cert-file":
+				config.Server.TLS.CertFile = *tlsCertFile
+			case "tls-private-key-file":
+				config.Server.TLS.KeyFile = *tlsPrivateKeyFile
+			case "client-ca-file":
+				config.Server.TLS.ClientCAFile = *clientCAFile
+			}
+		}
+	})
+	if err := ValidateConfig(config); err != nil {
+		glog.Fatalf("invalid config: %v", err)
+	}
+	var tlsReloader *TLSReloader
+	if config.Server.TLS != nil {
+		var err error
+		if tlsReloader, err = NewTLSReloader(*config.Server.TLS); err != nil {
+			glog.Fatalf("load tls files failed: %v", err)
+		}
+	}
+
+	// stopCh stops the background loops, abortCh aborts the binds in flight when shutdown runs out of time
+	stopCh := make(chan struct{})
+	abortCh := make(chan struct{})
+	reservations := NewReservationCache(config.ReservationTTL.Duration)
+	extendedResourceScheduler := &ExtendedResourceScheduler{
+		Reservations: reservations,
+		AbortCh:      abortCh,
+	}
+	// without the apiserver the objects are read from the storage directly, and nothing runs in the background
+	var clientset *kubernetes.Clientset
+	var resourceCache *ResourceCache
+	var recorder *EventRecorder
+	var claimController *ClaimController
+	var releaseController *ReleaseController
+	if dir := config.Storage.Directory; dir != "" {
+		storage, err := NewDirectoryStorage(dir)
+		if err != nil {
+			glog.Fatalf("load storage directory failed: %v", err)
+		}
+		glog.Infof("using the objects in %s instead of the apiserver, controllers and events are turned off", dir)
+		extendedResourceScheduler.Storage = storage
+	} else {
+		var err error
+		if clientset, err = CreateClientsetForConfig(config.Client); err != nil {
+			glog.Fatalf("create clientset error: %v", err)
+		}
+		resourceCache = NewResourceCache(clientset)
+		extendedResourceScheduler.Storage = NewAPIServerStorage(clientset)
+		extendedResourceScheduler.Cache = resourceCache
+		hostname, _ := os.Hostname()
+		recorder = NewEventRecorder(clientset.CoreV1(), hostname)
+		extendedResourceScheduler.Recorder = recorder
+		if config.LeaderElection.LeaderElect {
+			extendedResourceScheduler.Leader = NewLeaderElector(config.LeaderElection,
+				clientset.CoreV1().ConfigMaps(config.LeaderElection.LockNamespace))
+		}
+		if config.FeatureEnabled(ClaimControllerFeature) {
+			claimController = NewClaimController(extendedResourceScheduler, config.ClaimResyncPeriod.Duration)
+		}
+		if config.FeatureEnabled(ReleaseControllerFeature) {
+			releaseController = NewReleaseController(extendedResourceScheduler, config.ReleaseResyncPeriod.Duration)
+		}
 	}
 
+	healthChecker := &HealthChecker{Clientset: clientset, Cache: resourceCache}
+
 	mux = make(map[string]func(http.ResponseWriter, *http.Request))
-	mux["/scheduler/predicates"] = Predicates(clientset)
-	mux["/scheduler/bind"] = Bind(clientset)
+	mux[config.VerbPath(config.Server.PredicatesPath)] = Predicates(extendedResourceScheduler)
+	mux[config.VerbPath(config.Server.PrioritizePath)] = Prioritize(extendedResourceScheduler, config.PriorityStrategy)
+	mux[config.VerbPath(config.Server.BindPath)] = Bind(extendedResourceScheduler)
 
+	handler := &SchedulerHandler{
+		Endpoints: map[string]http.HandlerFunc{
+			healthzPath: Healthz(),
+			readyzPath:  Readyz(healthChecker),
+			metricsPath: Metrics(resourceCache),
+			explainPath: Explain(extendedResourceScheduler),
+		},
+		Synced:            healthChecker.Synced,
+		RequireClientCert: config.Server.TLS != nil && config.Server.TLS.ClientCAFile != "",
+	}
 	server := &http.Server{
-		Addr:         addr,
-		Handler:      &SchedulerHandler{},
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Addr:         config.Server.Address,
+		Handler:      handler,
+		ReadTimeout:  config.Server.ReadTimeout.Duration,
+		WriteTimeout: config.Server.WriteTimeout.Duration,
 	}
+	if tlsReloader != nil {
+		server.TLSConfig = tlsReloader.ServerTLSConfig()
+	}
+
+	shutdownCh := make(chan struct{})
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signalCh
+		glog.Infof("received signal %v, shutting down", sig)
+		close(shutdownCh)
+	}()
 
-	glog.V(2).Info("scheduler server is starting")
+	// the server is started before the cache syncs, so the probes are answered while it syncs
+	serverErrCh := make(chan error, 1)
+	go func() {
+		if tlsReloader == nil {
+			glog.V(2).Infof("scheduler server is starting on %s", config.Server.Address)
+			serverErrCh <- server.ListenAndServe()
+			return
+		}
+		glog.V(2).Infof("scheduler server is starting on %s with https, client certificate required by the extender verbs: %v",
+			config.Server.Address, config.Server.TLS.ClientCAFile != "")
+		serverErrCh <- server.ListenAndServeTLS("", "")
+	}()
 
-	if err := server.ListenAndServe(); err != nil {
+	synced := true
+	if resourceCache != nil {
+		resourceCache.Run(stopCh)
+		glog.V(2).Info("waiting for resource cache to sync")
+		synced = resourceCache.WaitForCacheSync(shutdownCh)
+	}
+	var loops sync.WaitGroup
+	if synced {
+		runLoop := func(run func(stopCh <-chan struct{}), stopCh <-chan struct{}) {
+			loops.Add(1)
+			go func() {
+				defer loops.Done()
+				run(stopCh)
+			}()
+		}
+		// the controllers write extendedresources and claims, so they only run while leading
+		runControllers := func(leaderStopCh <-chan struct{}) {
+			controllersStopCh := make(chan struct{})
+			go func() {
+				select {
+				case <-stopCh:
+				case <-leaderStopCh:
+				}
+				close(controllersStopCh)
+			}()
+			if claimController != nil {
+				runLoop(claimController.Run, controllersStopCh)
+			}
+			if releaseController != nil {
+				runLoop(releaseController.Run, controllersStopCh)
+			}
+		}
+		runLoop(reservations.Run, stopCh)
+		if recorder != nil {
+			runLoop(recorder.Run, stopCh)
+		}
+		if leader := extendedResourceScheduler.Leader; leader != nil {
+			leader.OnStartedLeading = runControllers
+			runLoop(leader.Run, stopCh)
+		} else {
+			runControllers(nil)
+		}
+	} else {
+		select {
+		case <-shutdownCh:
+		default:
+			glog.Fatal("resource cache sync failed")
+		}
+	}
+
+	select {
+	case err := <-serverErrCh:
 		glog.Fatalf("scheduler server start failed: %v", err)
+	case <-shutdownCh:
+	}
+
+	gracePeriod := config.Server.ShutdownGracePeriod.Duration
+	start := time.Now()
+	healthChecker.ShuttingDown()
+	drained := shutdownServer(server, handler, abortCh, gracePeriod)
+	close(stopCh)
+	if !waitTimeout(&loops, gracePeriod-time.Since(start)) {
+		glog.Errorf("background loops did not stop within the shutdown grace period %v", gracePeriod)
+		drained = false
+	}
+	glog.Infof("scheduler server is stopped")
+	glog.Flush()
+	if !drained {
+		os.Exit(1)
 	}
 }
 
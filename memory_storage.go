@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	podsResource                   = "pods"
+	nodesResource                  = "nodes"
+	extendedResourcesResource      = "extendedresources"
+	extendedResourceClaimsResource = "extendedresourceclaims"
+)
+
+// MemoryStorage is a Storage keeping the objects in memory. It checks resourceVersions on update
+// the same as the apiserver, so conflicts can be tested without a cluster.
+type MemoryStorage struct {
+	lock sync.RWMutex
+	// objects maps the resource to its objects keyed by namespace/name, or name if they are not namespaced
+	objects map[string]map[string]runtime.Object
+	// resourceVersion is the last resourceVersion given to an object
+	resourceVersion uint64
+	// written is called with the lock held after an object is changed, the change is undone if it fails
+	written func(resource, key string) error
+}
+
+// NewMemoryStorage creates an empty MemoryStorage, objects are added by Add
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		objects: map[string]map[string]runtime.Object{
+			podsResource:                   {},
+			nodesResource:                  {},
+			extendedResourcesResource:      {},
+			extendedResourceClaimsResource: {},
+		},
+	}
+}
+
+// Add stores copies of the pods, nodes, extendedresources and extendedresourceclaims,
+// replacing the objects with the same key. Objects without a resourceVersion are given one.
+func (s *MemoryStorage) Add(objs ...runtime.Object) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, obj := range objs {
+		resource, key, err := storageKey(obj)
+		if err != nil {
+			return err
+		}
+		obj = obj.DeepCopyObject()
+		accessor, _ := meta.Accessor(obj)
+		if accessor.GetResourceVersion() == "" {
+			accessor.SetResourceVersion(s.nextResourceVersion())
+		}
+		s.objects[resource][key] = obj
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetPod(namespace, name string) (*v1.Pod, error) {
+	obj, err := s.get(podsResource, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*v1.Pod), nil
+}
+
+// ListPods returns the pods of the namespace sorted by name
+func (s *MemoryStorage) ListPods(namespace string) ([]*v1.Pod, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	pods := make([]*v1.Pod, 0)
+	for _, obj := range s.objects[podsResource] {
+		if pod := obj.(*v1.Pod); pod.Namespace == namespace {
+			pods = append(pods, pod.DeepCopy())
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods, nil
+}
+
+func (s *MemoryStorage) Bind(namespace string, binding *v1.Binding) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := namespace + "/" + binding.Name
+	stored, ok := s.objects[podsResource][key]
+	if !ok {
+		return newStorageNotFoundError(podsResource, binding.Name)
+	}
+	pod := stored.DeepCopyObject().(*v1.Pod)
+	if binding.UID != "" && binding.UID != pod.UID {
+		return newStorageConflictError(podsResource, binding.Name, fmt.Errorf("the uid of the binding %s does not match the pod %s", binding.UID, pod.UID))
+	}
+	if pod.Spec.NodeName != "" {
+		return newStorageConflictError(podsResource, binding.Name, fmt.Errorf("pod %s is already assigned to node %q", binding.Name, pod.Spec.NodeName))
+	}
+	pod.Spec.NodeName = binding.Target.Name
+	pod.ResourceVersion = s.nextResourceVersion()
+	return s.commit(podsResource, key, pod)
+}
+
+func (s *MemoryStorage) GetNode(name string) (*v1.Node, error) {
+	obj, err := s.get(nodesResource, "", name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*v1.Node), nil
+}
+
+// ListNodes returns the nodes sorted by name
+func (s *MemoryStorage) ListNodes() ([]*v1.Node, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	nodes := make([]*v1.Node, 0, len(s.objects[nodesResource]))
+	for _, obj := range s.objects[nodesResource] {
+		nodes = append(nodes, obj.DeepCopyObject().(*v1.Node))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, nil
+}
+
+// UpdateNodeStatus writes the status of node, the rest of it is left as stored
+func (s *MemoryStorage) UpdateNodeStatus(node *v1.Node) (*v1.Node, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stored, err := s.checkUpdate(nodesResource, node)
+	if err != nil {
+		return nil, err
+	}
+	updated := stored.DeepCopyObject().(*v1.Node)
+	node.Status.DeepCopyInto(&updated.Status)
+	updated.ResourceVersion = s.nextResourceVersion()
+	if err := s.commit(nodesResource, node.Name, updated); err != nil {
+		return nil, err
+	}
+	return updated.DeepCopy(), nil
+}
+
+func (s *MemoryStorage) GetExtendedResource(name string) (*v1alpha1.ExtendedResource, error) {
+	obj, err := s.get(extendedResourcesResource, "", name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ExtendedResource), nil
+}
+
+func (s *MemoryStorage) UpdateExtendedResource(er *v1alpha1.ExtendedResource) (*v1alpha1.ExtendedResource, error) {
+	obj, err := s.update(extendedResourcesResource, er)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ExtendedResource), nil
+}
+
+func (s *MemoryStorage) GetExtendedResourceClaim(namespace, name string) (*v1alpha1.ExtendedResourceClaim, error) {
+	obj, err := s.get(extendedResourceClaimsResource, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ExtendedResourceClaim), nil
+}
+
+// ListExtendedResourceClaims returns the extendedresourceclaims sorted by namespace and name
+func (s *MemoryStorage) ListExtendedResourceClaims() ([]*v1alpha1.ExtendedResourceClaim, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	extendedResourceClaims := make([]*v1alpha1.ExtendedResourceClaim, 0, len(s.objects[extendedResourceClaimsResource]))
+	for _, obj := range s.objects[extendedResourceClaimsResource] {
+		extendedResourceClaims = append(extendedResourceClaims, obj.DeepCopyObject().(*v1alpha1.ExtendedResourceClaim))
+	}
+	sort.Slice(extendedResourceClaims, func(i, j int) bool {
+		a, b := extendedResourceClaims[i], extendedResourceClaims[j]
+		return a.Namespace < b.Namespace || a.Namespace == b.Namespace && a.Name < b.Name
+	})
+	return extendedResourceClaims, nil
+}
+
+func (s *MemoryStorage) UpdateExtendedResourceClaim(erc *v1alpha1.ExtendedResourceClaim) (*v1alpha1.ExtendedResourceClaim, error) {
+	obj, err := s.update(extendedResourceClaimsResource, erc)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ExtendedResourceClaim), nil
+}
+
+// get returns a copy of the object, so callers are free to modify it
+func (s *MemoryStorage) get(resource, namespace, name string) (runtime.Object, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+	obj, ok := s.objects[resource][key]
+	if !ok {
+		return nil, newStorageNotFoundError(resource, name)
+	}
+	return obj.DeepCopyObject(), nil
+}
+
+// update replaces the stored object with a copy of obj and returns another copy with the new resourceVersion
+func (s *MemoryStorage) update(resource string, obj runtime.Object) (runtime.Object, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.checkUpdate(resource, obj); err != nil {
+		return nil, err
+	}
+	_, key, _ := storageKey(obj)
+	updated := obj.DeepCopyObject()
+	accessor, _ := meta.Accessor(updated)
+	accessor.SetResourceVersion(s.nextResourceVersion())
+	if err := s.commit(resource, key, updated); err != nil {
+		return nil, err
+	}
+	return updated.DeepCopyObject(), nil
+}
+
+// checkUpdate returns the stored object obj replaces, or a conflict error if obj was read before the last change.
+// An empty resourceVersion updates unconditionally, the same as the apiserver.
+func (s *MemoryStorage) checkUpdate(resource string, obj runtime.Object) (runtime.Object, error) {
+	_, key, err := storageKey(obj)
+	if err != nil {
+		return nil, err
+	}
+	accessor, _ := meta.Accessor(obj)
+	stored, ok := s.objects[resource][key]
+	if !ok {
+		return nil, newStorageNotFoundError(resource, accessor.GetName())
+	}
+	storedAccessor, _ := meta.Accessor(stored)
+	if version := accessor.GetResourceVersion(); version != "" && version != storedAccessor.GetResourceVersion() {
+		return nil, newStorageConflictError(resource, accessor.GetName(),
+			fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+	return stored, nil
+}
+
+// commit stores obj under key, it is undone if written fails
+func (s *MemoryStorage) commit(resource, key string, obj runtime.Object) error {
+	prior := s.objects[resource][key]
+	s.objects[resource][key] = obj
+	if s.written == nil {
+		return nil
+	}
+	if err := s.written(resource, key); err != nil {
+		s.objects[resource][key] = prior
+		return err
+	}
+	return nil
+}
+
+func (s *MemoryStorage) nextResourceVersion() string {
+	s.resourceVersion++
+	return strconv.FormatUint(s.resourceVersion, 10)
+}
+
+// storageKey returns the resource of obj and its key among the objects of the resource
+func storageKey(obj runtime.Object) (string, string, error) {
+	var resource string
+	switch obj.(type) {
+	case *v1.Pod:
+		resource = podsResource
+	case *v1.Node:
+		resource = nodesResource
+	case *v1alpha1.ExtendedResource:
+		resource = extendedResourcesResource
+	case *v1alpha1.ExtendedResourceClaim:
+		resource = extendedResourceClaimsResource
+	default:
+		return "", "", fmt.Errorf("%T is not stored", obj)
+	}
+	key, err := objectKey(obj)
+	return resource, key, err
+}
+
+func newStorageNotFoundError(resource, name string) error {
+	return apierrors.NewNotFound(schema.GroupResource{Resource: resource}, name)
+}
+
+func newStorageConflictError(resource, name string, err error) error {
+	return apierrors.NewConflict(schema.GroupResource{Resource: resource}, name, err)
+}
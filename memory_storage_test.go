@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	err := storage.Add(
+		&v1alpha1.ExtendedResource{ObjectMeta: metav1.ObjectMeta{Name: "er1"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"gpu": "k80"}}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod1"}},
+	)
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if err := storage.Add(&v1.ConfigMap{}); err == nil {
+		t.Errorf("expected a configmap not to be stored")
+	}
+
+	if _, err := storage.GetExtendedResource("er2"); !apierrors.IsNotFound(err) {
+		t.Errorf("expected er2 not found, got %v", err)
+	}
+	if _, err := storage.GetPod("other", "pod1"); !apierrors.IsNotFound(err) {
+		t.Errorf("expected pod1 not found in namespace other, got %v", err)
+	}
+
+	// the object read is a copy, it is only changed in the storage by an update
+	er, err := storage.GetExtendedResource("er1")
+	if err != nil {
+		t.Fatalf("get er1 failed: %v", err)
+	}
+	stale := er.DeepCopy()
+	er.Status.Phase = v1alpha1.ExtendedResourceBound
+	if stored, _ := storage.GetExtendedResource("er1"); stored.Status.Phase != "" {
+		t.Errorf("expected the stored er1 not to change before update, got %s", stored.Status.Phase)
+	}
+	updated, err := storage.UpdateExtendedResource(er)
+	if err != nil {
+		t.Fatalf("update er1 failed: %v", err)
+	}
+	if updated.ResourceVersion == er.ResourceVersion || updated.Status.Phase != v1alpha1.ExtendedResourceBound {
+		t.Errorf("expected er1 bound with a new resourceVersion, got %+v", updated)
+	}
+	if _, err := storage.UpdateExtendedResource(stale); !apierrors.IsConflict(err) {
+		t.Errorf("expected the update of a stale er1 to conflict, got %v", err)
+	}
+	stale.ResourceVersion = ""
+	if _, err := storage.UpdateExtendedResource(stale); err != nil {
+		t.Errorf("expected an update without resourceVersion to succeed, got %v", err)
+	}
+	if _, err := storage.UpdateExtendedResourceClaim(&v1alpha1.ExtendedResourceClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "erc1"}}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the update of a missing claim to fail with not found, got %v", err)
+	}
+
+	// only the status of a node is updated
+	node, _ := storage.GetNode("node1")
+	node.Labels = nil
+	node.Status.ExtendedResourceAllocatable = []string{"er1"}
+	if _, err := storage.UpdateNodeStatus(node); err != nil {
+		t.Fatalf("update status of node1 failed: %v", err)
+	}
+	nodes, _ := storage.ListNodes()
+	if len(nodes) != 1 || nodes[0].Labels["gpu"] != "k80" || len(nodes[0].Status.ExtendedResourceAllocatable) != 1 {
+		t.Errorf("expected node1 with its labels and er1 allocatable, got %+v", nodes)
+	}
+
+	binding := &v1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod2"},
+		Target:     v1.ObjectReference{Kind: "Node", Name: "node1"},
+	}
+	if err := storage.Bind("default", binding); !apierrors.IsConflict(err) {
+		t.Errorf("expected a binding of another uid to conflict, got %v", err)
+	}
+	binding.UID = "pod1"
+	if err := storage.Bind("default", binding); err != nil {
+		t.Fatalf("bind pod1 failed: %v", err)
+	}
+	if pod, _ := storage.GetPod("default", "pod1"); pod.Spec.NodeName != "node1" {
+		t.Errorf("expected pod1 on node1, got %q", pod.Spec.NodeName)
+	}
+	if err := storage.Bind("default", binding); !apierrors.IsConflict(err) {
+		t.Errorf("expected a bound pod not to be bound again, got %v", err)
+	}
+}
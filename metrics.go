@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/api/extensions/v1alpha1"
+)
+
+// The metrics are written in the prometheus text exposition format by hand,
+// since the prometheus client library is not vendored.
+
+const metricsNamespace = "extendedresource_scheduler"
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	handlerDuration = newHistogramVec("handler_duration_seconds",
+		"Latency of the extender verbs handled, by handler.", []string{"handler"}, latencyBuckets)
+	filterNodeResults = newCounterVec("filter_node_results_total",
+		"Nodes evaluated by filter, by result and the failure kind of unschedulable nodes.", []string{"result", "reason"})
+	bindResults = newCounterVec("bind_total",
+		"Binds handled, by result.", []string{"result"})
+	apiRequestDuration = newHistogramVec("apiserver_request_duration_seconds",
+		"Latency of the requests made to the apiserver, by verb and resource.", []string{"verb", "resource"}, latencyBuckets)
+)
+
+// metricsCollectors are written to /metrics in this order
+var metricsCollectors = []collector{handlerDuration, filterNodeResults, bindResults, apiRequestDuration}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Metrics serves the metrics, the gauges of extended resources are computed from cache on every scrape
+func Metrics(cache *ResourceCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		for _, c := range metricsCollectors {
+			c.write(&buf)
+		}
+		if cache != nil {
+			writeExtendedResourceGauges(&buf, cache)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
+
+// observeHandler records the latency of an extender verb since start
+func observeHandler(handler string, start time.Time) {
+	handlerDuration.observe(time.Since(start).Seconds(), handler)
+}
+
+// observeAPIRequest records the latency of an apiserver request since start
+func observeAPIRequest(verb, resource string, start time.Time) {
+	apiRequestDuration.observe(time.Since(start).Seconds(), verb, resource)
+}
+
+// recordFilterResults counts every node evaluated by filter
+func recordFilterResults(schedulable int, failedNodes map[string]*FailureReason) {
+	if schedulable > 0 {
+		filterNodeResults.add(float64(schedulable), "schedulable", "")
+	}
+	for _, reason := range failedNodes {
+		filterNodeResults.add(1, "unschedulable", string(reason.Kind))
+	}
+}
+
+// recordBindResult counts a bind by whether it failed
+func recordBindResult(err string) {
+	if err == "" {
+		bindResults.add(1, "success")
+		return
+	}
+	bindResults.add(1, "failure")
+}
+
+// writeExtendedResourceGauges writes the number of extended resources allocatable on every node,
+// by raw resource name and phase
+func writeExtendedResourceGauges(w io.Writer, cache *ResourceCache) {
+	name := metricsNamespace + "_extended_resources"
+	fmt.Fprintf(w, "# HELP %s Extended resources allocatable on the node, by raw resource name and phase.\n", name)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	phases := []v1alpha1.ExtendedResourcePhase{v1alpha1.ExtendedResourceAvailable, v1alpha1.ExtendedResourcePending, v1alpha1.ExtendedResourceBound}
+	labelNames := []string{"node", "raw_resource_name", "phase"}
+	nodes := cache.ListNodes()
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	for _, node := range nodes {
+		counts := make(map[string]map[v1alpha1.ExtendedResourcePhase]int)
+		for _, er := range cache.ListExtendedResourcesByNode(node.Name) {
+			if counts[er.Spec.RawResourceName] == nil {
+				counts[er.Spec.RawResourceName] = make(map[v1alpha1.ExtendedResourcePhase]int)
+			}
+			counts[er.Spec.RawResourceName][er.Status.Phase]++
+		}
+		rawResourceNames := make([]string, 0, len(counts))
+		for rawResourceName := range counts {
+			rawResourceNames = append(rawResourceNames, rawResourceName)
+		}
+		sort.Strings(rawResourceNames)
+		for _, rawResourceName := range rawResourceNames {
+			for _, phase := range phases {
+				labels := formatLabels(labelNames, []string{node.Name, rawResourceName, string(phase)})
+				fmt.Fprintf(w, "%s%s %d\n", name, labels, counts[rawResourceName][phase])
+			}
+		}
+	}
+}
+
+// counterVec is a set of counters partitioned by label values
+type counterVec struct {
+	name, help string
+	labelNames []string
+
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labelNames []string) *counterVec {
+	return &counterVec{
+		name:       metricsNamespace + "_" + name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+	}
+}
+
+func (c *counterVec) add(value float64, labelValues ...string) {
+	key := formatLabels(c.labelNames, labelValues)
+	c.lock.Lock()
+	c.values[key] += value
+	c.lock.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, labels := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labels, formatValue(c.values[labels]))
+	}
+}
+
+// histogramVec is a set of histograms partitioned by label values
+type histogramVec struct {
+	name, help string
+	labelNames []string
+	buckets    []float64
+
+	lock   sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	// counts[i] is the number of observations not greater than buckets[i]
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, labelNames []string, buckets []float64) *histogramVec {
+	return &histogramVec{
+		name:       metricsNamespace + "_" + name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*histogram),
+	}
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	key := formatLabels(h.labelNames, labelValues)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	bucketLabelNames := append(append([]string{}, h.labelNames...), "le")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			labels := formatLabels(bucketLabelNames, append(append([]string{}, s.labelValues...), formatValue(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.counts[i])
+		}
+		labels := formatLabels(bucketLabelNames, append(append([]string{}, s.labelValues...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// formatLabels returns the labels in the exposition format, e.g. {handler="bind"}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(value)))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", value)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestRecordFilterResults(t *testing.T) {
+	recordFilterResults(2, map[string]*FailureReason{
+		"node1": {Kind: FailurePropertyMismatch, Claim: "erc1"},
+		"node2": {Kind: FailurePropertyMismatch, Claim: "erc2"},
+		"node3": {Kind: FailureReserved, ExtendedResources: []string{"er1"}},
+	})
+	var buf bytes.Buffer
+	filterNodeResults.write(&buf)
+	// failures are counted by kind, not by the claims and extended resources involved
+	for _, line := range []string{
+		`extendedresource_scheduler_filter_node_results_total{result="unschedulable",reason="PropertyMismatch"} 2`,
+		`extendedresource_scheduler_filter_node_results_total{result="unschedulable",reason="ReservedByOtherPod"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Test.", []string{"handler"}, []float64{0.1, 1})
+	h.observe(0.05, "bind")
+	h.observe(0.5, "bind")
+	h.observe(5, "bind")
+	var buf bytes.Buffer
+	h.write(&buf)
+	for _, line := range []string{
+		`extendedresource_scheduler_test_seconds_bucket{handler="bind",le="0.1"} 1`,
+		`extendedresource_scheduler_test_seconds_bucket{handler="bind",le="1"} 2`,
+		`extendedresource_scheduler_test_seconds_bucket{handler="bind",le="+Inf"} 3`,
+		`extendedresource_scheduler_test_seconds_sum{handler="bind"} 5.55`,
+		`extendedresource_scheduler_test_seconds_count{handler="bind"} 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestExtendedResourceGauges(t *testing.T) {
+	cache := NewResourceCache(&kubernetes.Clientset{})
+	for name, phase := range map[string]v1alpha1.ExtendedResourcePhase{
+		"er1": v1alpha1.ExtendedResourceAvailable,
+		"er2": v1alpha1.ExtendedResourceBound,
+		"er3": v1alpha1.ExtendedResourceBound,
+	} {
+		cache.extendedResources.store.add(&v1alpha1.ExtendedResource{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.ExtendedResourceSpec{RawResourceName: "nvidia.com/gpu"},
+			Status:     v1alpha1.ExtendedResourceStatus{Phase: phase},
+		})
+	}
+	cache.nodes.store.add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status:     v1.NodeStatus{ExtendedResourceAllocatable: []string{"er1", "er2", "er3"}},
+	})
+
+	var buf bytes.Buffer
+	writeExtendedResourceGauges(&buf, cache)
+	for _, line := range []string{
+		`extendedresource_scheduler_extended_resources{node="node1",raw_resource_name="nvidia.com/gpu",phase="Available"} 1`,
+		`extendedresource_scheduler_extended_resources{node="node1",raw_resource_name="nvidia.com/gpu",phase="Pending"} 0`,
+		`extendedresource_scheduler_extended_resources{node="node1",raw_resource_name="nvidia.com/gpu",phase="Bound"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestResourceCacheObservesAPIRequests(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer apiServer.Close()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewResourceCache(clientset)
+
+	// failed requests are observed as well
+	cache.nodes.listFunc()
+	cache.nodes.watchFunc(metav1.ListOptions{})
+	var buf bytes.Buffer
+	apiRequestDuration.write(&buf)
+	for _, line := range []string{
+		`extendedresource_scheduler_apiserver_request_duration_seconds_count{verb="list",resource="nodes"}`,
+		`extendedresource_scheduler_apiserver_request_duration_seconds_count{verb="watch",resource="nodes"}`,
+	} {
+		if !strings.Contains(buf.String(), line+" ") {
+			t.Errorf("expected line %q in:\n%s", line, buf.String())
+		}
+	}
+}
@@ -3,24 +3,24 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
-
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1alpha1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
 )
 
 // Predicates implemented filter functions.
 // The filter list is expected to be a subset of the supplied list.
-func Predicates(clientset *kubernetes.Clientset) http.HandlerFunc {
+func Predicates(extendedResourceScheduler *ExtendedResourceScheduler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		defer observeHandler("predicates", time.Now())
 		var buf bytes.Buffer
 		body := io.TeeReader(r.Body, &buf)
 		glog.V(2).Infof("body: %s", buf.String())
@@ -35,9 +35,6 @@ func Predicates(clientset *kubernetes.Clientset) http.HandlerFunc {
 				Error:       err.Error(),
 			}
 		} else {
-			extendedResourceScheduler := &ExtendedResourceScheduler{
-				Clientset: clientset,
-			}
 			extenderFilterResult = filter(extenderArgs, extendedResourceScheduler)
 		}
 
@@ -54,129 +51,358 @@ func Predicates(clientset *kubernetes.Clientset) http.HandlerFunc {
 
 func filter(extenderArgs schedulerapi.ExtenderArgs, extendedResourceScheduler *ExtendedResourceScheduler) *schedulerapi.ExtenderFilterResult {
 	pod := extenderArgs.Pod
-	nodes := extenderArgs.Nodes.Items
+	nodes, canNotSchedule := extenderNodes(extenderArgs, extendedResourceScheduler)
+	nodeCacheCapable := extenderArgs.NodeNames != nil
 
-	canSchedule := make([]v1.Node, 0, len(extenderArgs.Nodes.Items))
-	canNotSchedule := make(map[string]string)
 	// default all node scheduling failed
 	defaultNotSchedule := defaultFailedNodes(nodes)
+	for name, reason := range canNotSchedule {
+		defaultNotSchedule[name] = reason.String()
+	}
 
 	result := &schedulerapi.ExtenderFilterResult{
-		Nodes: &v1.NodeList{
-			Items: canSchedule,
-		},
 		FailedNodes: defaultNotSchedule,
 		Error:       "",
 	}
+	if nodeCacheCapable {
+		result.NodeNames = &[]string{}
+	} else {
+		result.Nodes = &v1.NodeList{
+			Items: []v1.Node{},
+		}
+	}
 
-	extendedResourceClaims, err := extendedResourceScheduler.FindExtendedResourceClaimList(pod)
+	canSchedule, failedNodes, err := filterNodes(pod, nodes, extendedResourceScheduler)
 	if err != nil {
 		result.Error = err.Error()
 		return result
 	}
+	for name, reason := range failedNodes {
+		canNotSchedule[name] = reason
+	}
 
-	// calculate how much extendedResource are needed for pod
-	// TODO: Check whether the user's declared rawResourceName is the same as the declared rawResourceName of extended resource
-	var extendedResourceNames = make([]string, 0)
-	for _, erc := range extendedResourceClaims {
-		extendedResourceNames = append(extendedResourceNames, erc.Spec.ExtendedResourceNames...)
+	result.FailedNodes = failureMessages(canNotSchedule)
+	recordFilterResults(len(canSchedule), canNotSchedule)
+	if len(canSchedule) == 0 && len(canNotSchedule) > 0 {
+		extendedResourceScheduler.Recorder.Eventf(&pod, v1.EventTypeWarning, reasonExtendedResourcesUnsatisfied,
+			"%s", summarizeFailures(canNotSchedule))
+	}
+	if nodeCacheCapable {
+		nodeNames := make([]string, 0, len(canSchedule))
+		for _, node := range canSchedule {
+			nodeNames = append(nodeNames, node.Name)
+		}
+		result.NodeNames = &nodeNames
+	} else {
+		result.Nodes.Items = canSchedule
+	}
+	return result
+}
+
+// filterNodes returns the nodes which can satisfy the extendedresourceclaims of pod, and why the others can not.
+// The allocation plan on every feasible node is kept for bind if reservations are enabled, nothing is reserved.
+func filterNodes(pod v1.Pod, nodes []v1.Node, extendedResourceScheduler *ExtendedResourceScheduler) ([]v1.Node, map[string]*FailureReason, error) {
+	extendedResourceClaims, err := extendedResourceScheduler.FindExtendedResourceClaimList(pod)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	glog.V(2).Info("start to filter node")
 
+	// plans of an earlier filter of the pod are replaced by this one
+	if extendedResourceScheduler.Reservations != nil {
+		extendedResourceScheduler.Reservations.Release(pod.UID)
+	}
+
+	canSchedule := make([]v1.Node, 0, len(nodes))
+	canNotSchedule := make(map[string]*FailureReason)
 	// TODO: check the extended resources of the node asynchronously
 	for _, node := range nodes {
-		nodeName := node.Name
-		extendedResourceAllocatable := node.Status.ExtendedResourceAllocatable
-		if len(extendedResourceAllocatable) < len(extendedResourceNames) {
-			canNotSchedule[nodeName] = "extended resources that can be allocated on this node are less than pod needs"
+		allocation, reason := allocateExtendedResources(pod.UID, extendedResourceClaims, node, extendedResourceScheduler)
+		if reason != nil {
+			canNotSchedule[node.Name] = reason
 			continue
 		}
+		// the plan is only kept in memory, bind reserves and writes it for the node kube-scheduler chooses
+		if reservations := extendedResourceScheduler.Reservations; reservations != nil {
+			reservations.AddPlan(pod.UID, node.Name, allocation)
+		}
+		canSchedule = append(canSchedule, node)
+	}
+	return canSchedule, canNotSchedule, nil
+}
 
-		if ss, b := sliceInSlice(extendedResourceNames, extendedResourceAllocatable); !b {
-			canNotSchedule[nodeName] = fmt.Sprintf("there are no such [%s] extended resource", strings.Join(ss, " "))
-			continue
+// extenderNodes returns the candidate nodes of extenderArgs.
+// When kube-scheduler is configured with nodeCacheCapable only node names are sent,
+// the nodes are looked up from the cache and those not found are returned with the failure reason.
+func extenderNodes(extenderArgs schedulerapi.ExtenderArgs, extendedResourceScheduler *ExtendedResourceScheduler) ([]v1.Node, map[string]*FailureReason) {
+	failedNodes := make(map[string]*FailureReason)
+	if extenderArgs.NodeNames == nil {
+		if extenderArgs.Nodes == nil {
+			return nil, failedNodes
 		}
+		return extenderArgs.Nodes.Items, failedNodes
+	}
 
-		extendedResources, err := extendedResourceScheduler.FindExtendedResourceList(extendedResourceAllocatable)
+	nodes := make([]v1.Node, 0, len(*extenderArgs.NodeNames))
+	for _, name := range *extenderArgs.NodeNames {
+		node, err := extendedResourceScheduler.FindNode(name)
 		if err != nil {
-			canNotSchedule[nodeName] = err.Error()
+			failedNodes[name] = &FailureReason{Kind: FailureNodeNotFound, Detail: err.Error()}
 			continue
 		}
+		nodes = append(nodes, *node)
+	}
+	return nodes, failedNodes
+}
 
-		// filter out the er specified in erc and er status is not available
-		scheduled := false
-		extendedResourceAvailable := make([]*v1alpha1.ExtendedResource, 0)
-		extendedResourceAvailable = append(extendedResourceAvailable, extendedResources...)
-		if len(extendedResourceNames) > 0 {
-		loop:
-			for i := 0; i < len(extendedResourceAvailable); i++ {
-				for _, name := range extendedResourceNames {
-					if name != extendedResourceAvailable[i].Name {
-						continue
-					}
-					if extendedResourceAvailable[i].Status.Phase != v1alpha1.ExtendedResourceAvailable {
-						scheduled = true
-						break loop
-					}
-					extendedResourceAvailable = append(extendedResourceAvailable[:i], extendedResourceAvailable[i+1:]...)
-				}
-			}
-			if scheduled {
-				canNotSchedule[nodeName] = "there are unavailable extended resources in extendedresourceclaim"
-				continue
+// allocateExtendedResources selects extended resources on node for every claim of the pod.
+// It returns the allocation plan of the pod on the node, or the reason why the node can not satisfy the pod.
+// It neither modifies the claims nor writes anything, so every node is evaluated independently.
+// Extended resources reserved by other pods are regarded as unavailable.
+func allocateExtendedResources(podUID types.UID, extendedResourceClaims []*v1alpha1.ExtendedResourceClaim, node v1.Node, extendedResourceScheduler *ExtendedResourceScheduler) (allocationPlan, *FailureReason) {
+	// calculate how much extendedResource are needed for pod
+	// TODO: Check whether the user's declared rawResourceName is the same as the declared rawResourceName of extended resource
+	var extendedResourceNames = make([]string, 0)
+	for _, erc := range extendedResourceClaims {
+		extendedResourceNames = append(extendedResourceNames, erc.Spec.ExtendedResourceNames...)
+	}
+
+	extendedResourceAllocatable := node.Status.ExtendedResourceAllocatable
+	if len(extendedResourceAllocatable) < len(extendedResourceNames) {
+		return nil, &FailureReason{Kind: FailureNotEnough}
+	}
+
+	if ss, b := sliceInSlice(extendedResourceNames, extendedResourceAllocatable); !b {
+		return nil, &FailureReason{Kind: FailureUnavailable, Claim: claimOf(extendedResourceClaims, ss[0]), ExtendedResources: ss, Detail: "not on node"}
+	}
+
+	extendedResources, err := extendedResourceScheduler.FindExtendedResourcesOnNode(&node)
+	if err != nil {
+		return nil, &FailureReason{Kind: FailureError, Detail: err.Error()}
+	}
+
+	// filter out the er specified in erc and er status is not available
+	// extended resources whose node affinity does not match are left out
+	unnamed := make([]*v1alpha1.ExtendedResource, 0, len(extendedResources))
+	extendedResourceAvailable := make([]*v1alpha1.ExtendedResource, 0, len(extendedResources))
+	for _, er := range extendedResources {
+		matchesNode := extendedResourceMatchesNode(er, &node)
+		if !containsString(extendedResourceNames, er.Name) {
+			unnamed = append(unnamed, er)
+			if matchesNode {
+				extendedResourceAvailable = append(extendedResourceAvailable, er)
 			}
+			continue
+		}
+		ercName := claimOf(extendedResourceClaims, er.Name)
+		if !matchesNode {
+			return nil, &FailureReason{Kind: FailureAffinityMismatch, Claim: ercName, ExtendedResources: []string{er.Name}}
+		}
+		if er.Status.Phase != v1alpha1.ExtendedResourceAvailable {
+			return nil, &FailureReason{Kind: FailureUnavailable, Claim: ercName, ExtendedResources: []string{er.Name}, Detail: phaseDetail(er.Status.Phase)}
 		}
+		if reservedByOther(extendedResourceScheduler, er.Name, podUID) {
+			return nil, &FailureReason{Kind: FailureReserved, Claim: ercName, ExtendedResources: []string{er.Name}}
+		}
+	}
 
-		for _, erc := range extendedResourceClaims {
-			erNames := erc.Spec.ExtendedResourceNames
-			erNum := erc.Spec.ExtendedResourceNum
-			requirements := erc.Spec.MetadataRequirements
-			rawResourceName := erc.Spec.RawResourceName
-
-			for i := 0; i < len(extendedResourceAvailable); i++ {
-				er := extendedResourceAvailable[i]
-				prop := er.Spec.Properties
-				if int64(len(erNames)) < erNum && rawResourceName == er.Spec.RawResourceName &&
-					(mapInMap(requirements.MatchLabels, prop) ||
-						labelMatchesLabelSelectorExpressions(requirements.MatchExpressions, prop)) {
-					erNames = append(erNames, er.Name)
-					er.Spec.ExtendedResourceClaimName = erc.Name
-					extendedResourceAvailable = append(extendedResourceAvailable[:i], extendedResourceAvailable[i+1:]...)
-				}
-			}
-			if erNum != 0 && int64(len(erNames)) < erNum {
-				canNotSchedule[nodeName] = fmt.Sprintf("extended resource that can be allocated are not satisfy [%s] needs", erc.Name)
-				continue
+	// the claims are assigned together, so a claim with loose requirements does not take
+	// the only extended resources which satisfy another claim
+	needs := make([]int, len(extendedResourceClaims))
+	candidates := make([][]int, len(extendedResourceClaims))
+	selectors := make([]*propertySelector, len(extendedResourceClaims))
+	for i, erc := range extendedResourceClaims {
+		selector, err := newPropertySelector(erc.Spec.MetadataRequirements)
+		if err != nil {
+			return nil, &FailureReason{Kind: FailureInvalidClaim, Claim: erc.Name, Detail: err.Error()}
+		}
+		selectors[i] = selector
+		if need := erc.Spec.ExtendedResourceNum - int64(len(erc.Spec.ExtendedResourceNames)); need > 0 {
+			needs[i] = int(need)
+		}
+		for j, er := range extendedResourceAvailable {
+			if erc.Spec.RawResourceName == er.Spec.RawResourceName &&
+				er.Status.Phase == v1alpha1.ExtendedResourceAvailable &&
+				!reservedByOther(extendedResourceScheduler, er.Name, podUID) &&
+				selector.Matches(er.Spec.Properties) {
+				candidates[i] = append(candidates[i], j)
 			}
-			erc.Spec.ExtendedResourceNames = erNames
-			erc.Status.Phase = v1alpha1.ExtendedResourceClaimPending
-			erc.Status.Reason = "extended resource have been satisfied and waiting to bound"
 		}
+	}
+	// the costs only matter if there are more extended resources than the claims need
+	costs := make([]int, len(extendedResourceAvailable))
+	total := 0
+	for _, need := range needs {
+		total += need
+	}
+	if len(extendedResourceAvailable) > total {
+		pending := pendingClaimsOfOthers(extendedResourceClaims, extendedResourceScheduler)
+		for j, er := range extendedResourceAvailable {
+			costs[j] = extendedResourceDemand(er, pending)
+		}
+	}
+
+	assignment, unsatisfied, ok := assignExtendedResources(needs, candidates, costs)
+	if !ok {
+		return nil, diagnoseUnsatisfiedClaim(podUID, extendedResourceClaims[unsatisfied], needs[unsatisfied], selectors[unsatisfied],
+			unnamed, &node, extendedResourceScheduler)
+	}
+
+	allocation := make(allocationPlan)
+	for i, erc := range extendedResourceClaims {
+		erNames := append([]string{}, erc.Spec.ExtendedResourceNames...)
+		for _, j := range assignment[i] {
+			erNames = append(erNames, extendedResourceAvailable[j].Name)
+		}
+		allocation[erc.Name] = erNames
+	}
+	return allocation, nil
+}
 
-		for _, erc := range extendedResourceClaims {
-			if erc.Status.Phase != v1alpha1.ExtendedResourceClaimPending {
-				scheduled = true
-				break
+// pendingClaimsOfOthers returns the requirements of the pending extendedresourceclaims other than extendedResourceClaims.
+// Claims whose requirements are invalid are left out, and none are returned if they can not be listed.
+func pendingClaimsOfOthers(extendedResourceClaims []*v1alpha1.ExtendedResourceClaim, extendedResourceScheduler *ExtendedResourceScheduler) []pendingClaim {
+	all, err := extendedResourceScheduler.FindPendingExtendedResourceClaims()
+	if err != nil {
+		glog.Errorf("find pending extendedresourceclaims: %v", err)
+		return nil
+	}
+	own := sets.NewString()
+	for _, erc := range extendedResourceClaims {
+		own.Insert(erc.Namespace + "/" + erc.Name)
+	}
+	pending := make([]pendingClaim, 0, len(all))
+	for _, erc := range all {
+		if own.Has(erc.Namespace + "/" + erc.Name) {
+			continue
+		}
+		selector, err := newPropertySelector(erc.Spec.MetadataRequirements)
+		if err != nil {
+			continue
+		}
+		pending = append(pending, pendingClaim{rawResourceName: erc.Spec.RawResourceName, selector: selector})
+	}
+	return pending
+}
+
+// diagnoseUnsatisfiedClaim finds the first requirement of erc which leaves fewer extended resources than it needs.
+// The requirements are checked from the claim itself to the state of the cluster, so the reason names
+// what the user can change first. If every requirement leaves enough, the extended resources are
+// taken by the other claims of the pod, and it is reported as not enough.
+func diagnoseUnsatisfiedClaim(podUID types.UID, erc *v1alpha1.ExtendedResourceClaim, need int, selector *propertySelector,
+	extendedResources []*v1alpha1.ExtendedResource, node *v1.Node, extendedResourceScheduler *ExtendedResourceScheduler) *FailureReason {
+	requirements := claimRequirements(podUID, erc, selector, node, extendedResourceScheduler)
+	remaining := extendedResources
+	for _, requirement := range requirements {
+		var matched, mismatched []*v1alpha1.ExtendedResource
+		for _, er := range remaining {
+			if requirement.matches(er) {
+				matched = append(matched, er)
+			} else {
+				mismatched = append(mismatched, er)
 			}
 		}
-		if !scheduled {
-			canSchedule = append(canSchedule, node)
-		} else {
-			canNotSchedule[nodeName] = "node can allocate extended resource are not satisfy pod needs"
+		if len(matched) >= need {
+			remaining = matched
 			continue
 		}
+		if len(mismatched) == 0 {
+			// nothing was left out by the requirement, there were not enough extended resources before it
+			break
+		}
+		reason := &FailureReason{Kind: requirement.kind, Claim: erc.Name}
+		switch requirement.kind {
+		case FailureRawResourceMismatch:
+			reason.Detail = erc.Spec.RawResourceName
+		case FailureAffinityMismatch, FailureReserved:
+			reason.ExtendedResources = extendedResourceNames(mismatched)
+		case FailureUnavailable:
+			reason.ExtendedResources = extendedResourceNames(mismatched)
+			reason.Detail = phaseDetail(mismatched[0].Status.Phase)
+			for _, er := range mismatched {
+				if er.Status.Phase != mismatched[0].Status.Phase {
+					reason.Detail = "not available"
+				}
+			}
+		}
+		return reason
+	}
+	return &FailureReason{Kind: FailureNotEnough, Claim: erc.Name}
+}
+
+// claimRequirement is a requirement an extended resource must meet to be allocated to a claim
+type claimRequirement struct {
+	kind    FailureKind
+	matches func(er *v1alpha1.ExtendedResource) bool
+	// named is true if the requirement also applies to the extended resources named by the claim
+	named bool
+}
+
+// claimRequirements returns the requirements of erc, from the claim itself to the state of the cluster
+func claimRequirements(podUID types.UID, erc *v1alpha1.ExtendedResourceClaim, selector *propertySelector,
+	node *v1.Node, extendedResourceScheduler *ExtendedResourceScheduler) []claimRequirement {
+	return []claimRequirement{
+		{
+			kind:    FailureRawResourceMismatch,
+			matches: func(er *v1alpha1.ExtendedResource) bool { return er.Spec.RawResourceName == erc.Spec.RawResourceName },
+		},
+		{
+			kind:    FailurePropertyMismatch,
+			matches: func(er *v1alpha1.ExtendedResource) bool { return selector.Matches(er.Spec.Properties) },
+		},
+		{
+			kind:    FailureAffinityMismatch,
+			matches: func(er *v1alpha1.ExtendedResource) bool { return extendedResourceMatchesNode(er, node) },
+			named:   true,
+		},
+		{
+			kind:    FailureUnavailable,
+			matches: func(er *v1alpha1.ExtendedResource) bool { return er.Status.Phase == v1alpha1.ExtendedResourceAvailable },
+			named:   true,
+		},
+		{
+			kind: FailureReserved,
+			matches: func(er *v1alpha1.ExtendedResource) bool {
+				return !reservedByOther(extendedResourceScheduler, er.Name, podUID)
+			},
+			named: true,
+		},
 	}
+}
 
-	// pod can be scheduled, so erc need to update, erc is pending
-	if len(canSchedule) > 0 {
-		for _, erc := range extendedResourceClaims {
-			extendedResourceScheduler.UpdateExtendedResourceClaim(pod.Namespace, erc)
+// claimOf returns the name of the claim which names the extended resource
+func claimOf(extendedResourceClaims []*v1alpha1.ExtendedResourceClaim, erName string) string {
+	for _, erc := range extendedResourceClaims {
+		if containsString(erc.Spec.ExtendedResourceNames, erName) {
+			return erc.Name
 		}
 	}
-	result.FailedNodes = canNotSchedule
-	result.Nodes.Items = canSchedule
-	return result
+	return ""
+}
+
+// phaseDetail describes an extended resource which is not Available by its phase
+func phaseDetail(phase v1alpha1.ExtendedResourcePhase) string {
+	if phase == "" {
+		return "not available"
+	}
+	return strings.ToLower(string(phase))
+}
+
+func extendedResourceNames(extendedResources []*v1alpha1.ExtendedResource) []string {
+	names := make([]string, 0, len(extendedResources))
+	for _, er := range extendedResources {
+		names = append(names, er.Name)
+	}
+	return names
+}
+
+// reservedByOther returns true if the extended resource is reserved by a pod other than podUID
+func reservedByOther(extendedResourceScheduler *ExtendedResourceScheduler, erName string, podUID types.UID) bool {
+	if extendedResourceScheduler.Reservations == nil {
+		return false
+	}
+	return extendedResourceScheduler.Reservations.AssumedByOther(erName, podUID)
 }
 
 // default set all node is fail
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+func TestExtenderNodes(t *testing.T) {
+	extendedResourceScheduler := &ExtendedResourceScheduler{Cache: newTestCache(newTestNode("node1"), newTestNode("node2"))}
+	tests := []struct {
+		name     string
+		args     schedulerapi.ExtenderArgs
+		expected []string
+		notFound []string
+	}{
+		{
+			name:     "nodes sent",
+			args:     schedulerapi.ExtenderArgs{Nodes: &v1.NodeList{Items: []v1.Node{*newTestNode("node3")}}},
+			expected: []string{"node3"},
+		},
+		{
+			name:     "node names looked up from the cache",
+			args:     schedulerapi.ExtenderArgs{NodeNames: &[]string{"node2", "node1"}},
+			expected: []string{"node2", "node1"},
+		},
+		{
+			name:     "node name missing from the cache",
+			args:     schedulerapi.ExtenderArgs{NodeNames: &[]string{"node1", "node3"}},
+			expected: []string{"node1"},
+			notFound: []string{"node3"},
+		},
+		{
+			name: "nothing sent",
+		},
+	}
+	for _, test := range tests {
+		nodes, failedNodes := extenderNodes(test.args, extendedResourceScheduler)
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s: expected nodes %v, got %v", test.name, test.expected, names)
+		}
+		if len(failedNodes) != len(test.notFound) {
+			t.Errorf("%s: expected failed nodes %v, got %v", test.name, test.notFound, failedNodes)
+		}
+		for _, name := range test.notFound {
+			if reason := failedNodes[name]; reason == nil || reason.Kind != FailureNodeNotFound {
+				t.Errorf("%s: expected %s to fail with %s, got %+v", test.name, name, FailureNodeNotFound, reason)
+			}
+		}
+	}
+}
+
+func TestFilterNodeNames(t *testing.T) {
+	k80 := map[string]string{"model": "k80"}
+	cache := newTestCache(
+		newTestER("er1", k80),
+		newTestER("er2", map[string]string{"model": "p100"}),
+		newTestNode("node1", "er1"),
+		newTestNode("node2", "er2"),
+		newTestClaim("erc1", 1, k80),
+	)
+	extendedResourceScheduler := &ExtendedResourceScheduler{Cache: cache}
+
+	// kube-scheduler configured with nodeCacheCapable sends and expects node names only
+	result := filter(schedulerapi.ExtenderArgs{
+		Pod:       *newTestPod("pod1", "erc1"),
+		NodeNames: &[]string{"node1", "node2", "node3"},
+	}, extendedResourceScheduler)
+	if result.Error != "" {
+		t.Fatalf("unexpected error: %s", result.Error)
+	}
+	if result.Nodes != nil {
+		t.Errorf("expected no nodes in the result, got %+v", result.Nodes)
+	}
+	if result.NodeNames == nil || !reflect.DeepEqual(*result.NodeNames, []string{"node1"}) {
+		t.Errorf("expected node names [node1], got %v", result.NodeNames)
+	}
+	if message := result.FailedNodes["node2"]; message != "not enough extended resources matching erc1" {
+		t.Errorf("expected node2 to fail for erc1, got %q", message)
+	}
+	if message := result.FailedNodes["node3"]; !strings.HasPrefix(message, "node not found: ") {
+		t.Errorf("expected node3 not found, got %q", message)
+	}
+}
+
+func TestFilterDoesNotReserve(t *testing.T) {
+	storage := NewMemoryStorage()
+	err := storage.Add(
+		newTestER("er1", nil), newTestER("er2", nil),
+		newTestNode("node1", "er1"), newTestNode("node2", "er2"),
+		newTestClaim("erc1", 1, nil), newTestClaim("erc2", 1, nil),
+		newTestPod("pod1", "erc1"), newTestPod("pod2", "erc2"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extendedResourceScheduler := &ExtendedResourceScheduler{Storage: storage, Reservations: NewReservationCache(time.Minute)}
+	filterPod := func(pod *v1.Pod) []string {
+		result := filter(schedulerapi.ExtenderArgs{Pod: *pod, NodeNames: &[]string{"node1", "node2"}}, extendedResourceScheduler)
+		if result.Error != "" || result.NodeNames == nil {
+			t.Fatalf("filter %s failed: %+v", pod.Name, result)
+		}
+		return *result.NodeNames
+	}
+
+	// filter of pod1 keeps plans on both nodes without taking er1 or er2 from pod2
+	pod1, pod2 := newTestPod("pod1", "erc1"), newTestPod("pod2", "erc2")
+	if names := filterPod(pod1); !reflect.DeepEqual(names, []string{"node1", "node2"}) {
+		t.Errorf("expected pod1 to fit on node1 and node2, got %v", names)
+	}
+	if names := filterPod(pod2); !reflect.DeepEqual(names, []string{"node1", "node2"}) {
+		t.Errorf("expected pod2 to fit on node1 and node2, got %v", names)
+	}
+
+	// once pod1 is bound to node1, er1 is taken and pod2 only fits on node2
+	result := bind(schedulerapi.ExtenderBindingArgs{PodName: "pod1", PodNamespace: "default", PodUID: "pod1", Node: "node1"}, extendedResourceScheduler)
+	if result.Error != "" {
+		t.Fatalf("bind pod1 failed: %s", result.Error)
+	}
+	if !extendedResourceScheduler.Reservations.AssumedByOther("er1", "pod2") {
+		t.Errorf("expected er1 reserved by pod1 after bind")
+	}
+	if names := filterPod(pod2); !reflect.DeepEqual(names, []string{"node2"}) {
+		t.Errorf("expected pod2 to fit on node2 only, got %v", names)
+	}
+}
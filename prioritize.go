@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+const (
+	// maxPriority is the highest score a node can get, the same as kube-scheduler
+	maxPriority = 10
+
+	// BinPackStrategy prefers nodes whose extended resources are already mostly bound
+	BinPackStrategy = "binpack"
+	// SpreadStrategy prefers nodes whose extended resources are mostly available
+	SpreadStrategy = "spread"
+)
+
+// ValidatePriorityStrategy checks whether strategy is a supported scoring strategy
+func ValidatePriorityStrategy(strategy string) error {
+	switch strategy {
+	case BinPackStrategy, SpreadStrategy:
+		return nil
+	}
+	return fmt.Errorf("unsupported priority strategy %q, must be %q or %q", strategy, BinPackStrategy, SpreadStrategy)
+}
+
+// Prioritize implemented prioritize functions.
+// Every node gets a score between 0 and 10 according to the strategy.
+func Prioritize(extendedResourceScheduler *ExtendedResourceScheduler, strategy string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer observeHandler("prioritize", time.Now())
+		var buf bytes.Buffer
+		body := io.TeeReader(r.Body, &buf)
+
+		var extenderArgs schedulerapi.ExtenderArgs
+		var hostPriorityList *schedulerapi.HostPriorityList
+
+		if err := json.NewDecoder(body).Decode(&extenderArgs); err != nil {
+			glog.Errorf("decode prioritize args error: %v", err)
+			hostPriorityList = &schedulerapi.HostPriorityList{}
+		} else {
+			hostPriorityList = prioritize(extenderArgs, extendedResourceScheduler, strategy)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if resultBody, err := json.Marshal(hostPriorityList); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
+		}
+	}
+}
+
+func prioritize(extenderArgs schedulerapi.ExtenderArgs, extendedResourceScheduler *ExtendedResourceScheduler, strategy string) *schedulerapi.HostPriorityList {
+	pod := extenderArgs.Pod
+	nodes, _ := extenderNodes(extenderArgs, extendedResourceScheduler)
+
+	hostPriorityList := make(schedulerapi.HostPriorityList, 0, len(nodes))
+	extendedResourceClaims, err := extendedResourceScheduler.FindExtendedResourceClaimList(pod)
+	if err != nil {
+		glog.Errorf("find extendedresourceclaims of pod %s/%s error: %v", pod.Namespace, pod.Name, err)
+		for _, node := range nodes {
+			hostPriorityList = append(hostPriorityList, schedulerapi.HostPriority{Host: node.Name})
+		}
+		return &hostPriorityList
+	}
+
+	for _, node := range nodes {
+		hostPriorityList = append(hostPriorityList, schedulerapi.HostPriority{
+			Host:  node.Name,
+			Score: scoreNode(pod, extendedResourceClaims, node, extendedResourceScheduler, strategy),
+		})
+	}
+	return &hostPriorityList
+}
+
+// scoreNode calculates the score of node with the extended resources the pod would use.
+// Only extended resources the claims could use on the node, whatever their state, are taken into account,
+// those bound or reserved by other pods are counted as used.
+func scoreNode(pod v1.Pod, extendedResourceClaims []*v1alpha1.ExtendedResourceClaim, node v1.Node, extendedResourceScheduler *ExtendedResourceScheduler, strategy string) int {
+	allocation, reason := allocateExtendedResources(pod.UID, extendedResourceClaims, node, extendedResourceScheduler)
+	if reason != nil {
+		glog.V(3).Infof("node %s can not satisfy pod: %s", node.Name, reason)
+		return 0
+	}
+
+	requirements := make([][]claimRequirement, 0, len(extendedResourceClaims))
+	for _, erc := range extendedResourceClaims {
+		selector, err := newPropertySelector(erc.Spec.MetadataRequirements)
+		if err != nil {
+			return 0
+		}
+		requirements = append(requirements, claimRequirements(pod.UID, erc, selector, &node, extendedResourceScheduler))
+	}
+	extendedResources, err := extendedResourceScheduler.FindExtendedResourcesOnNode(&node)
+	if err != nil {
+		return 0
+	}
+
+	total, used := 0, 0
+	for _, er := range extendedResources {
+		if !wantedByClaims(er, requirements) {
+			continue
+		}
+		total++
+		// extended resources reserved by other pods being bound are as good as bound
+		if er.Status.Phase != v1alpha1.ExtendedResourceAvailable || reservedByOther(extendedResourceScheduler, er.Name, pod.UID) {
+			used++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	// count the extended resources which will be bound to the pod
+	for _, erNames := range allocation {
+		for _, name := range erNames {
+			if containsString(node.Status.ExtendedResourceAllocatable, name) {
+				used++
+			}
+		}
+	}
+	if used > total {
+		used = total
+	}
+
+	if strategy == SpreadStrategy {
+		return maxPriority * (total - used) / total
+	}
+	return maxPriority * used / total
+}
+
+// wantedByClaims returns true if er meets the requirements of one of the claims on the raw resource name,
+// the properties and the node affinity, whether it is available or not
+func wantedByClaims(er *v1alpha1.ExtendedResource, requirements [][]claimRequirement) bool {
+	for _, claim := range requirements {
+		wanted := true
+		for _, requirement := range claim {
+			if requirement.kind == FailureUnavailable || requirement.kind == FailureReserved {
+				continue
+			}
+			if !requirement.matches(er) {
+				wanted = false
+				break
+			}
+		}
+		if wanted {
+			return true
+		}
+	}
+	return false
+}
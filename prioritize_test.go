@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+func TestPrioritize(t *testing.T) {
+	other := newTestER("er4", nil)
+	other.Spec.RawResourceName = "example.com/fpga"
+	elsewhere := newTestER("er4", nil)
+	elsewhere.Spec.NodeAffinity = &v1alpha1.ResourceNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+		MatchExpressions: []v1.NodeSelectorRequirement{{Key: "kubernetes.io/hostname", Operator: v1.NodeSelectorOpIn, Values: []string{"node2"}}},
+	}}}}
+	tests := []struct {
+		name     string
+		ers      []runtime.Object
+		reserved []string
+		binpack  int
+		spread   int
+	}{
+		{
+			name:    "all available",
+			ers:     []runtime.Object{newTestER("er1", nil), newTestER("er2", nil), newTestER("er3", nil), newTestER("er4", nil)},
+			binpack: 2,
+			spread:  7,
+		},
+		{
+			name: "half bound",
+			ers: []runtime.Object{
+				newTestER("er1", nil), newTestER("er2", nil),
+				boundTo(newTestER("er3", nil), "erc2"), boundTo(newTestER("er4", nil), "erc3"),
+			},
+			binpack: 7,
+			spread:  2,
+		},
+		{
+			name: "bound and reserved by another pod",
+			ers: []runtime.Object{
+				newTestER("er1", nil), newTestER("er2", nil),
+				boundTo(newTestER("er3", nil), "erc2"), newTestER("er4", nil),
+			},
+			reserved: []string{"er4"},
+			binpack:  7,
+			spread:   2,
+		},
+		{
+			name: "bound extended resource of another raw resource ignored",
+			ers: []runtime.Object{
+				newTestER("er1", nil), newTestER("er2", nil),
+				boundTo(newTestER("er3", nil), "erc2"), boundTo(other, "erc3"),
+			},
+			binpack: 6,
+			spread:  3,
+		},
+		{
+			name: "reserved extended resource with another node affinity ignored",
+			ers: []runtime.Object{
+				newTestER("er1", nil), newTestER("er2", nil),
+				boundTo(newTestER("er3", nil), "erc2"), elsewhere,
+			},
+			reserved: []string{"er4"},
+			binpack:  6,
+			spread:   3,
+		},
+		{
+			name: "nothing available",
+			ers: []runtime.Object{
+				withPhase(newTestER("er1", nil), v1alpha1.ExtendedResourcePending), boundTo(newTestER("er2", nil), "erc2"),
+				boundTo(newTestER("er3", nil), "erc2"), boundTo(newTestER("er4", nil), "erc3"),
+			},
+			binpack: 0,
+			spread:  0,
+		},
+	}
+	for _, test := range tests {
+		pod := newTestPod("pod1", "erc1")
+		objs := append([]runtime.Object{newTestNode("node1", "er1", "er2", "er3", "er4"), newTestClaim("erc1", 1, nil)}, test.ers...)
+		reservations := NewReservationCache(time.Minute)
+		if len(test.reserved) > 0 {
+			if err := reservations.Confirm("pod2", "node1", allocationPlan{"erc4": test.reserved}); err != nil {
+				t.Fatalf("%s: confirm failed: %v", test.name, err)
+			}
+		}
+		extendedResourceScheduler := &ExtendedResourceScheduler{Cache: newTestCache(objs...), Reservations: reservations}
+		for strategy, expected := range map[string]int{BinPackStrategy: test.binpack, SpreadStrategy: test.spread} {
+			result := prioritize(schedulerapi.ExtenderArgs{Pod: *pod, NodeNames: &[]string{"node1"}}, extendedResourceScheduler, strategy)
+			if len(*result) != 1 || (*result)[0].Score != expected {
+				t.Errorf("%s: expected %s score %d, got %+v", test.name, strategy, expected, *result)
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	claimReleasedReason = "extended resources are released since no running pod uses the claim"
+)
+
+// ReleaseController returns extended resources to Available when the pods using their claims
+// have terminated or been deleted. A claim is only released if no other live pod still uses it.
+type ReleaseController struct {
+	extendedResourceScheduler *ExtendedResourceScheduler
+	resyncPeriod              time.Duration
+
+	lock sync.Mutex
+	// pending holds the namespace/name keys of the claims to check
+	pending sets.String
+	syncCh  chan struct{}
+}
+
+// NewReleaseController creates a ReleaseController which reads from the cache of extendedResourceScheduler
+func NewReleaseController(extendedResourceScheduler *ExtendedResourceScheduler, resyncPeriod time.Duration) *ReleaseController {
+	c := &ReleaseController{
+		extendedResourceScheduler: extendedResourceScheduler,
+		resyncPeriod:              resyncPeriod,
+		pending:                   sets.NewString(),
+		syncCh:                    make(chan struct{}, 1),
+	}
+	extendedResourceScheduler.Cache.AddEventHandler(func(eventType watch.EventType, obj runtime.Object) {
+		pod, ok := obj.(*v1.Pod)
+		if !ok {
+			return
+		}
+		if eventType == watch.Deleted || podTerminated(pod) {
+			c.enqueue(pod)
+		}
+	})
+	return c
+}
+
+func (c *ReleaseController) enqueue(pod *v1.Pod) {
+	c.lock.Lock()
+	for _, container := range pod.Spec.Containers {
+		for _, ercName := range container.ExtendedResourceClaims {
+			c.pending.Insert(pod.Namespace + "/" + ercName)
+		}
+	}
+	c.lock.Unlock()
+
+	select {
+	case c.syncCh <- struct{}{}:
+	default:
+	}
+}
+
+// Run releases claims of terminated pods as they are seen, and checks all bound claims every resyncPeriod,
+// until stopCh is closed
+func (c *ReleaseController) Run(stopCh <-chan struct{}) {
+	glog.V(2).Info("release controller is starting")
+	ticker := time.NewTicker(c.resyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			glog.V(2).Info("release controller is stopped")
+			return
+		case <-c.syncCh:
+			c.lock.Lock()
+			keys := c.pending.List()
+			c.pending = sets.NewString()
+			c.lock.Unlock()
+			for _, key := range keys {
+				parts := strings.SplitN(key, "/", 2)
+				c.sync(parts[0], parts[1])
+			}
+		case <-ticker.C:
+			cache := c.extendedResourceScheduler.Cache
+			claims := append(cache.ListExtendedResourceClaimsByPhase(v1alpha1.ExtendedResourceClaimBound),
+				cache.ListExtendedResourceClaimsByPhase(v1alpha1.ExtendedResourceClaimLost)...)
+			for _, erc := range claims {
+				c.sync(erc.Namespace, erc.Name)
+			}
+		}
+	}
+}
+
+func (c *ReleaseController) sync(namespace, name string) {
+	erc, err := c.extendedResourceScheduler.Cache.GetExtendedResourceClaim(namespace, name)
+	if err != nil {
+		glog.V(3).Infof("skip releasing extendedresourceclaim %s/%s: %v", namespace, name, err)
+		return
+	}
+	if err := c.releaseClaim(erc); err != nil {
+		glog.Errorf("release extendedresourceclaim %s/%s failed: %v", namespace, name, err)
+	}
+}
+
+// releaseClaim returns the extended resources bound to the claim to Available and the claim to Pending,
+// unless a live pod still uses the claim
+func (c *ReleaseController) releaseClaim(erc *v1alpha1.ExtendedResourceClaim) error {
+	if erc.Status.Phase != v1alpha1.ExtendedResourceClaimBound && erc.Status.Phase != v1alpha1.ExtendedResourceClaimLost {
+		return nil
+	}
+	if pod := livePod(c.extendedResourceScheduler.Cache.ListPodsByClaim(erc.Namespace, erc.Name)); pod != nil {
+		glog.V(4).Infof("extendedresourceclaim %s/%s is still used by pod %s", erc.Namespace, erc.Name, pod.Name)
+		return nil
+	}
+	// a running pod may be missing from the cache while it lists again, so the pods are read
+	// from the storage before the extended resources are given to other pods
+	pods, err := c.extendedResourceScheduler.Storage.ListPods(erc.Namespace)
+	if err != nil {
+		return fmt.Errorf("list pods: %v", err)
+	}
+	if pod := livePod(podsUsingClaim(pods, erc.Name)); pod != nil {
+		glog.V(2).Infof("extendedresourceclaim %s/%s is still used by pod %s missing from the cache", erc.Namespace, erc.Name, pod.Name)
+		return nil
+	}
+
+	glog.V(2).Infof("releasing extendedresourceclaim %s/%s", erc.Namespace, erc.Name)
+	for _, name := range erc.Spec.ExtendedResourceNames {
+		er, err := c.extendedResourceScheduler.FindExtendedResource(name)
+		if err != nil {
+			// deleted extended resources need no release
+			continue
+		}
+		if er.Spec.ExtendedResourceClaimName != erc.Name {
+			continue
+		}
+		ercName := erc.Name
+		released, err := c.extendedResourceScheduler.UpdateExtendedResource(er, func(er *v1alpha1.ExtendedResource) error {
+			if er.Spec.ExtendedResourceClaimName != ercName {
+				return fmt.Errorf("extendedresource %s is bound to %q by others", er.Name, er.Spec.ExtendedResourceClaimName)
+			}
+			er.Spec.ExtendedResourceClaimName = ""
+			er.Status.Phase = v1alpha1.ExtendedResourceAvailable
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		c.extendedResourceScheduler.Recorder.Eventf(released, v1.EventTypeNormal, reasonExtendedResourceReleased,
+			"released from extendedresourceclaim %s/%s", erc.Namespace, erc.Name)
+	}
+
+	released, err := c.extendedResourceScheduler.UpdateExtendedResourceClaim(erc, func(erc *v1alpha1.ExtendedResourceClaim) error {
+		if erc.Status.Phase != v1alpha1.ExtendedResourceClaimBound && erc.Status.Phase != v1alpha1.ExtendedResourceClaimLost {
+			return fmt.Errorf("phase changed to %s by others", erc.Status.Phase)
+		}
+		releaseClaim(erc)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.extendedResourceScheduler.Recorder.Eventf(released, v1.EventTypeNormal, reasonClaimReleased,
+		"released extended resources %s, the pods using the claim are terminated", strings.Join(erc.Spec.ExtendedResourceNames, ","))
+	return nil
+}
+
+// releaseClaim removes the extended resource names added by the scheduler from erc and sets it pending
+func releaseClaim(erc *v1alpha1.ExtendedResourceClaim) {
+	if allocated, ok := erc.Annotations[allocatedExtendedResourcesAnnotation]; ok {
+		allocatedNames := strings.Split(allocated, ",")
+		erNames := make([]string, 0, len(erc.Spec.ExtendedResourceNames))
+		for _, name := range erc.Spec.ExtendedResourceNames {
+			if !containsString(allocatedNames, name) {
+				erNames = append(erNames, name)
+			}
+		}
+		erc.Spec.ExtendedResourceNames = erNames
+		delete(erc.Annotations, allocatedExtendedResourcesAnnotation)
+	}
+	erc.Status.Phase = v1alpha1.ExtendedResourceClaimPending
+	erc.Status.Reason = claimReleasedReason
+}
+
+// livePod returns the first of pods which has not terminated, or nil
+func livePod(pods []*v1.Pod) *v1.Pod {
+	for _, pod := range pods {
+		if !podTerminated(pod) {
+			return pod
+		}
+	}
+	return nil
+}
+
+// podsUsingClaim returns the pods with a container using the extendedresourceclaim ercName
+func podsUsingClaim(pods []*v1.Pod, ercName string) []*v1.Pod {
+	using := make([]*v1.Pod, 0)
+	for _, pod := range pods {
+		for _, container := range pod.Spec.Containers {
+			if containsString(container.ExtendedResourceClaims, ercName) {
+				using = append(using, pod)
+				break
+			}
+		}
+	}
+	return using
+}
+
+// podTerminated returns true if all containers of the pod have terminated and will not be restarted
+func podTerminated(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
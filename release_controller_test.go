@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestReleaseClaim(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		erNames     []string
+		expected    []string
+	}{
+		{
+			name:     "names given by user are kept",
+			erNames:  []string{"er1"},
+			expected: []string{"er1"},
+		},
+		{
+			name:        "names allocated by scheduler are removed",
+			annotations: map[string]string{allocatedExtendedResourcesAnnotation: "er2,er3"},
+			erNames:     []string{"er1", "er2", "er3"},
+			expected:    []string{"er1"},
+		},
+	}
+	for _, test := range tests {
+		erc := &v1alpha1.ExtendedResourceClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "erc1", Annotations: test.annotations},
+			Spec:       v1alpha1.ExtendedResourceClaimSpec{ExtendedResourceNames: test.erNames},
+			Status:     v1alpha1.ExtendedResourceClaimStatus{Phase: v1alpha1.ExtendedResourceClaimBound},
+		}
+		releaseClaim(erc)
+		if !reflect.DeepEqual(erc.Spec.ExtendedResourceNames, test.expected) {
+			t.Errorf("%s: expected names %v, got %v", test.name, test.expected, erc.Spec.ExtendedResourceNames)
+		}
+		if _, ok := erc.Annotations[allocatedExtendedResourcesAnnotation]; ok {
+			t.Errorf("%s: allocated annotation should be removed", test.name)
+		}
+		if erc.Status.Phase != v1alpha1.ExtendedResourceClaimPending {
+			t.Errorf("%s: expected phase %s, got %s", test.name, v1alpha1.ExtendedResourceClaimPending, erc.Status.Phase)
+		}
+	}
+}
+
+func TestReleaseControllerReleaseClaim(t *testing.T) {
+	podInPhase := func(name string, phase v1.PodPhase) *v1.Pod {
+		pod := newTestPod(name, "erc1")
+		pod.Status.Phase = phase
+		return pod
+	}
+	tests := []struct {
+		name     string
+		pods     []*v1.Pod
+		uncached bool
+		released bool
+	}{
+		{
+			name:     "pod succeeded",
+			pods:     []*v1.Pod{podInPhase("pod1", v1.PodSucceeded)},
+			released: true,
+		},
+		{
+			name:     "pod failed",
+			pods:     []*v1.Pod{podInPhase("pod1", v1.PodFailed)},
+			released: true,
+		},
+		{
+			name:     "no pod left",
+			released: true,
+		},
+		{
+			name: "claim still used by a running pod",
+			pods: []*v1.Pod{podInPhase("pod1", v1.PodSucceeded), podInPhase("pod2", v1.PodRunning)},
+		},
+		{
+			name: "claim still used by a pending pod",
+			pods: []*v1.Pod{podInPhase("pod1", v1.PodPending)},
+		},
+		{
+			name:     "claim still used by a running pod missing from the cache",
+			pods:     []*v1.Pod{podInPhase("pod1", v1.PodRunning)},
+			uncached: true,
+		},
+	}
+	for _, test := range tests {
+		erc := newTestClaim("erc1", 2, nil)
+		erc.Annotations = map[string]string{allocatedExtendedResourcesAnnotation: "er1,er2"}
+		erc.Spec.ExtendedResourceNames = []string{"er1", "er2"}
+		erc.Status.Phase = v1alpha1.ExtendedResourceClaimBound
+		objs := []runtime.Object{
+			boundTo(newTestER("er1", nil), "erc1"), boundTo(newTestER("er2", nil), "erc1"),
+			boundTo(newTestER("er3", nil), "erc2"), erc,
+		}
+		cached := objs
+		for _, pod := range test.pods {
+			objs = append(objs, pod)
+		}
+		if !test.uncached {
+			cached = objs
+		}
+		storage := NewMemoryStorage()
+		if err := storage.Add(objs...); err != nil {
+			t.Fatalf("%s: add failed: %v", test.name, err)
+		}
+		c := NewReleaseController(&ExtendedResourceScheduler{Storage: storage, Cache: newTestCache(cached...)}, time.Minute)
+
+		if err := c.releaseClaim(erc); err != nil {
+			t.Errorf("%s: release failed: %v", test.name, err)
+			continue
+		}
+		for _, name := range []string{"er1", "er2"} {
+			er, _ := storage.GetExtendedResource(name)
+			if released := er.Status.Phase == v1alpha1.ExtendedResourceAvailable && er.Spec.ExtendedResourceClaimName == ""; released != test.released {
+				t.Errorf("%s: expected %s released %v, got %+v", test.name, name, test.released, er)
+			}
+		}
+		if er, _ := storage.GetExtendedResource("er3"); er.Status.Phase != v1alpha1.ExtendedResourceBound {
+			t.Errorf("%s: expected er3 of another claim to stay bound, got %s", test.name, er.Status.Phase)
+		}
+		current, _ := storage.GetExtendedResourceClaim("default", "erc1")
+		if test.released {
+			if current.Status.Phase != v1alpha1.ExtendedResourceClaimPending || len(current.Spec.ExtendedResourceNames) != 0 {
+				t.Errorf("%s: expected erc1 pending without extended resources, got %+v", test.name, current)
+			}
+		} else if current.Status.Phase != v1alpha1.ExtendedResourceClaimBound || !reflect.DeepEqual(current.Spec.ExtendedResourceNames, []string{"er1", "er2"}) {
+			t.Errorf("%s: expected erc1 to stay bound to er1 and er2, got %+v", test.name, current)
+		}
+	}
+}
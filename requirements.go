@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// Operators comparing a property with a number or a quantity such as 16Gi, in addition to the
+// label selector operators. A range is expressed by two requirements on the same key.
+const (
+	PropertySelectorOpGt metav1.LabelSelectorOperator = "Gt"
+	PropertySelectorOpGe metav1.LabelSelectorOperator = "Ge"
+	PropertySelectorOpLt metav1.LabelSelectorOperator = "Lt"
+	PropertySelectorOpLe metav1.LabelSelectorOperator = "Le"
+)
+
+// propertySelector matches the properties of extended resources against the metadata requirements of a claim
+type propertySelector struct {
+	labels     labels.Selector
+	quantities []quantityRequirement
+}
+
+// quantityRequirement compares a property as a quantity
+type quantityRequirement struct {
+	key      string
+	operator metav1.LabelSelectorOperator
+	value    resource.Quantity
+}
+
+// Matches returns true if properties satisfy all requirements
+func (s *propertySelector) Matches(properties map[string]string) bool {
+	if !s.labels.Matches(labels.Set(properties)) {
+		return false
+	}
+	for _, r := range s.quantities {
+		if !r.matches(properties) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns false if the property is missing or is not a quantity
+func (r quantityRequirement) matches(properties map[string]string) bool {
+	v, ok := properties[r.key]
+	if !ok {
+		return false
+	}
+	q, err := resource.ParseQuantity(v)
+	if err != nil {
+		glog.V(4).Infof("property %s=%q is not a quantity, regarding as not match", r.key, v)
+		return false
+	}
+	cmp := q.Cmp(r.value)
+	switch r.operator {
+	case PropertySelectorOpGt:
+		return cmp > 0
+	case PropertySelectorOpGe:
+		return cmp >= 0
+	case PropertySelectorOpLt:
+		return cmp < 0
+	case PropertySelectorOpLe:
+		return cmp <= 0
+	}
+	return false
+}
+
+// propertiesMatchRequirements checks whether the properties of an extended resource satisfy the metadata
+// requirements of a claim. Both MatchLabels and MatchExpressions must be satisfied, and every one of them
+// is ANDed, empty requirements match all properties. Invalid requirements match nothing.
+func propertiesMatchRequirements(requirements metav1.LabelSelector, properties map[string]string) bool {
+	selector, err := newPropertySelector(requirements)
+	if err != nil {
+		glog.V(3).Infof("Failed to parse MetadataRequirements: %+v, regarding as not match: %v", requirements, err)
+		return false
+	}
+	return selector.Matches(properties)
+}
+
+// newPropertySelector converts the metadata requirements of a claim into a propertySelector
+func newPropertySelector(ls metav1.LabelSelector) (*propertySelector, error) {
+	selector := &propertySelector{labels: labels.NewSelector()}
+	for k, v := range ls.MatchLabels {
+		r, err := labels.NewRequirement(k, selection.Equals, []string{v})
+		if err != nil {
+			return nil, err
+		}
+		selector.labels = selector.labels.Add(*r)
+	}
+	for _, expr := range ls.MatchExpressions {
+		var op selection.Operator
+		switch expr.Operator {
+		case metav1.LabelSelectorOpIn:
+			op = selection.In
+		case metav1.LabelSelectorOpNotIn:
+			op = selection.NotIn
+		case metav1.LabelSelectorOpExists:
+			op = selection.Exists
+		case metav1.LabelSelectorOpDoesNotExist:
+			op = selection.DoesNotExist
+		case PropertySelectorOpGt, PropertySelectorOpGe, PropertySelectorOpLt, PropertySelectorOpLe:
+			r, err := newQuantityRequirement(expr)
+			if err != nil {
+				return nil, err
+			}
+			selector.quantities = append(selector.quantities, r)
+			continue
+		default:
+			return nil, fmt.Errorf("%q is not a valid metadata requirement operator", expr.Operator)
+		}
+		r, err := labels.NewRequirement(expr.Key, op, expr.Values)
+		if err != nil {
+			return nil, err
+		}
+		selector.labels = selector.labels.Add(*r)
+	}
+	return selector, nil
+}
+
+func newQuantityRequirement(expr metav1.LabelSelectorRequirement) (quantityRequirement, error) {
+	if len(expr.Values) != 1 {
+		return quantityRequirement{}, fmt.Errorf("operator %q of key %q needs exactly one value, got %d", expr.Operator, expr.Key, len(expr.Values))
+	}
+	value, err := resource.ParseQuantity(expr.Values[0])
+	if err != nil {
+		return quantityRequirement{}, fmt.Errorf("value %q of key %q is not a number or quantity: %v", expr.Values[0], expr.Key, err)
+	}
+	return quantityRequirement{key: expr.Key, operator: expr.Operator, value: value}, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPropertiesMatchRequirements(t *testing.T) {
+	properties := map[string]string{"type": "k80", "memory": "24Gi", "capability": "6.1", "nvlink": "true"}
+	tests := []struct {
+		name         string
+		requirements metav1.LabelSelector
+		expected     bool
+	}{
+		{
+			name:         "empty requirements match everything",
+			requirements: metav1.LabelSelector{},
+			expected:     true,
+		},
+		{
+			name:         "all match labels match",
+			requirements: metav1.LabelSelector{MatchLabels: map[string]string{"type": "k80", "memory": "24Gi"}},
+			expected:     true,
+		},
+		{
+			name:         "match labels are ANDed",
+			requirements: metav1.LabelSelector{MatchLabels: map[string]string{"type": "k80", "memory": "12G"}},
+			expected:     false,
+		},
+		{
+			name:         "missing property",
+			requirements: metav1.LabelSelector{MatchLabels: map[string]string{"type": "k80", "ecc": "true"}},
+			expected:     false,
+		},
+		{
+			name: "match expressions are ANDed",
+			requirements: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "type", Operator: metav1.LabelSelectorOpIn, Values: []string{"k80", "p100"}},
+				{Key: "ecc", Operator: metav1.LabelSelectorOpExists},
+			}},
+			expected: false,
+		},
+		{
+			name: "empty match labels do not short-circuit expressions",
+			requirements: metav1.LabelSelector{
+				MatchLabels: map[string]string{},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "type", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"k80"}},
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "labels and expressions both match",
+			requirements: metav1.LabelSelector{
+				MatchLabels: map[string]string{"type": "k80"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "nvlink", Operator: metav1.LabelSelectorOpIn, Values: []string{"true"}},
+					{Key: "ecc", Operator: metav1.LabelSelectorOpDoesNotExist},
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "labels match but expressions do not",
+			requirements: metav1.LabelSelector{
+				MatchLabels: map[string]string{"type": "k80"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "nvlink", Operator: metav1.LabelSelectorOpIn, Values: []string{"false"}},
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "expressions match but labels do not",
+			requirements: metav1.LabelSelector{
+				MatchLabels: map[string]string{"type": "p100"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "nvlink", Operator: metav1.LabelSelectorOpIn, Values: []string{"true"}},
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "quantity greater than",
+			requirements: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "memory", Operator: PropertySelectorOpGt, Values: []string{"16Gi"}},
+			}},
+			expected: true,
+		},
+		{
+			name: "quantity at least with different units",
+			requirements: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "memory", Operator: PropertySelectorOpGe, Values: []string{"24576Mi"}},
+			}},
+			expected: true,
+		},
+		{
+			name: "quantity less than",
+			requirements: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "memory", Operator: PropertySelectorOpLt, Values: []string{"24Gi"}},
+			}},
+			expected: false,
+		},
+		{
+			name: "decimal range",
+			requirements: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "capability", Operator: PropertySelectorOpGt, Values: []string{"6"}},
+				{Key: "capability", Operator: PropertySelectorOpLe, Values: []string{"7"}},
+			}},
+			expected: true,
+		},
+		{
+			name: "decimal out of range",
+			requirements: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "capability", Operator: PropertySelectorOpGe, Values: []string{"7"}},
+				{Key: "capability", Operator: PropertySelectorOpLt, Values: []string{"8"}},
+			}},
+			expected: false,
+		},
+		{
+			name: "comparison with a non quantity property",
+			requirements: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "type", Operator: PropertySelectorOpGt, Values: []string{"1"}},
+			}},
+			expected: false,
+		},
+		{
+			name: "comparison with a missing property",
+			requirements: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "cores", Operator: PropertySelectorOpGt, Values: []string{"1"}},
+			}},
+			expected: false,
+		},
+		{
+			name: "invalid operator does not match",
+			requirements: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "type", Operator: "Like", Values: []string{"k80"}},
+			}},
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		if got := propertiesMatchRequirements(test.requirements, properties); got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestNewPropertySelectorInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		expr metav1.LabelSelectorRequirement
+	}{
+		{
+			name: "unknown operator",
+			expr: metav1.LabelSelectorRequirement{Key: "type", Operator: "Like", Values: []string{"k80"}},
+		},
+		{
+			name: "comparison without value",
+			expr: metav1.LabelSelectorRequirement{Key: "memory", Operator: PropertySelectorOpGt},
+		},
+		{
+			name: "comparison with several values",
+			expr: metav1.LabelSelectorRequirement{Key: "memory", Operator: PropertySelectorOpLt, Values: []string{"8Gi", "16Gi"}},
+		},
+		{
+			name: "comparison with a non quantity value",
+			expr: metav1.LabelSelectorRequirement{Key: "memory", Operator: PropertySelectorOpGe, Values: []string{"large"}},
+		},
+	}
+	for _, test := range tests {
+		requirements := metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{test.expr}}
+		if _, err := newPropertySelector(requirements); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
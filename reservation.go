@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ReservationCache keeps the allocation plans computed by filter for pods until bind, and the
+// extended resources reserved by bind for the node kube-scheduler chose, so that concurrent
+// filter and bind calls do not hand out the same available extended resource twice.
+// Filter only keeps a plan per candidate node, nothing is reserved until bind confirms one of them.
+// Plans and reservations expire after ttl if they are never confirmed or released.
+type ReservationCache struct {
+	lock sync.Mutex
+	ttl  time.Duration
+	// reservations is keyed by pod UID
+	reservations map[types.UID]*reservation
+	// assumed maps an extended resource name to the pod UID whose confirmed plan reserves it
+	assumed map[string]types.UID
+	now     func() time.Time
+}
+
+// allocationPlan maps claim name to the extended resource names selected for it
+type allocationPlan map[string][]string
+
+// names returns all extended resource names of the plan
+func (p allocationPlan) names() []string {
+	names := make([]string, 0)
+	for _, erNames := range p {
+		names = append(names, erNames...)
+	}
+	return names
+}
+
+type reservation struct {
+	// nodes maps node name to the allocation plan of the pod on it
+	nodes     map[string]allocationPlan
+	confirmed bool
+	expires   time.Time
+}
+
+// NewReservationCache creates a ReservationCache whose reservations expire after ttl
+func NewReservationCache(ttl time.Duration) *ReservationCache {
+	return &ReservationCache{
+		ttl:          ttl,
+		reservations: make(map[types.UID]*reservation),
+		assumed:      make(map[string]types.UID),
+		now:          time.Now,
+	}
+}
+
+// Run removes expired reservations periodically until stopCh is closed
+func (c *ReservationCache) Run(stopCh <-chan struct{}) {
+	period := c.ttl / 2
+	if period < time.Second {
+		period = time.Second
+	}
+	wait.Until(c.cleanupExpired, period, stopCh)
+}
+
+// reservedError is returned by Confirm when extended resources of the plan are reserved by another pod
+type reservedError struct {
+	names []string
+}
+
+func (e *reservedError) Error() string {
+	return fmt.Sprintf("extended resources [%s] are reserved by another pod", strings.Join(e.names, " "))
+}
+
+// AddPlan keeps the allocation plan of the pod on the node until bind. Nothing is reserved,
+// so a pod may have plans on many nodes sharing extended resources with the plans of other pods.
+func (c *ReservationCache) AddPlan(podUID types.UID, nodeName string, plan allocationPlan) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	r, ok := c.reservations[podUID]
+	if !ok {
+		r = &reservation{nodes: make(map[string]allocationPlan)}
+		c.reservations[podUID] = r
+	}
+	r.nodes[nodeName] = plan
+	r.expires = c.now().Add(c.ttl)
+}
+
+// Confirm reserves the extended resources of plan for the pod on the node chosen by kube-scheduler
+// and drops its plans on the other nodes. It fails without reserving anything if one of them is
+// already reserved by another pod.
+// The reservation lasts for ttl, so that filter keeps treating its extended resources as taken
+// until the cache has seen them bound.
+func (c *ReservationCache) Confirm(podUID types.UID, nodeName string, plan allocationPlan) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	erNames := plan.names()
+	taken := make([]string, 0)
+	for _, name := range erNames {
+		if uid, ok := c.assumedBy(name); ok && uid != podUID {
+			taken = append(taken, name)
+		}
+	}
+	if len(taken) > 0 {
+		return &reservedError{names: taken}
+	}
+
+	c.release(podUID)
+	c.reservations[podUID] = &reservation{
+		nodes:     map[string]allocationPlan{nodeName: plan},
+		confirmed: true,
+		expires:   c.now().Add(c.ttl),
+	}
+	for _, name := range erNames {
+		c.assumed[name] = podUID
+	}
+	return nil
+}
+
+// Plan returns a copy of the allocation plan of the pod on the node, expired plans are ignored
+func (c *ReservationCache) Plan(podUID types.UID, nodeName string) (allocationPlan, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	r, ok := c.reservations[podUID]
+	if !ok || c.now().After(r.expires) {
+		return nil, false
+	}
+	plan, ok := r.nodes[nodeName]
+	if !ok {
+		return nil, false
+	}
+	copied := make(allocationPlan, len(plan))
+	for ercName, erNames := range plan {
+		copied[ercName] = append([]string{}, erNames...)
+	}
+	return copied, true
+}
+
+// Release removes all plans and reservations of the pod
+func (c *ReservationCache) Release(podUID types.UID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.release(podUID)
+}
+
+// AssumedByOther returns true if the extended resource is reserved by a pod other than podUID
+func (c *ReservationCache) AssumedByOther(erName string, podUID types.UID) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	uid, ok := c.assumedBy(erName)
+	return ok && uid != podUID
+}
+
+// assumedBy returns the pod UID which reserved the extended resource, expired reservations are ignored.
+// It must be called with the lock held.
+func (c *ReservationCache) assumedBy(erName string) (types.UID, bool) {
+	uid, ok := c.assumed[erName]
+	if !ok {
+		return "", false
+	}
+	if r, ok := c.reservations[uid]; !ok || c.now().After(r.expires) {
+		return "", false
+	}
+	return uid, true
+}
+
+// release must be called with the lock held
+func (c *ReservationCache) release(podUID types.UID) {
+	r, ok := c.reservations[podUID]
+	if !ok {
+		return
+	}
+	for _, plan := range r.nodes {
+		for _, name := range plan.names() {
+			if c.assumed[name] == podUID {
+				delete(c.assumed, name)
+			}
+		}
+	}
+	delete(c.reservations, podUID)
+}
+
+func (c *ReservationCache) cleanupExpired() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	for uid, r := range c.reservations {
+		if now.After(r.expires) {
+			if !r.confirmed {
+				glog.V(2).Infof("reservation of pod %s expired before bind", uid)
+			}
+			c.release(uid)
+		}
+	}
+}
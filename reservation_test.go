@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReservationCache(t *testing.T) {
+	now := time.Now()
+	c := NewReservationCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	// plans kept for filter reserve nothing
+	c.AddPlan("pod1", "node1", allocationPlan{"erc1": {"er1", "er2"}})
+	c.AddPlan("pod1", "node2", allocationPlan{"erc1": {"er3"}})
+	c.AddPlan("pod2", "node1", allocationPlan{"erc2": {"er2", "er4"}})
+	if c.AssumedByOther("er1", "pod2") {
+		t.Errorf("er1 should not be reserved by a plan of filter")
+	}
+	if plan, ok := c.Plan("pod1", "node2"); !ok || len(plan["erc1"]) != 1 || plan["erc1"][0] != "er3" {
+		t.Errorf("unexpected plan of pod1 on node2: %v", plan)
+	}
+
+	// bind chose node1 for pod1, its plan on node2 is dropped
+	if err := c.Confirm("pod1", "node1", allocationPlan{"erc1": {"er1", "er2"}}); err != nil {
+		t.Fatalf("confirm failed: %v", err)
+	}
+	if _, ok := c.Plan("pod1", "node2"); ok {
+		t.Errorf("plan of pod1 on node2 should be dropped")
+	}
+	if !c.AssumedByOther("er1", "pod2") {
+		t.Errorf("er1 should be reserved by pod1")
+	}
+	if c.AssumedByOther("er1", "pod1") {
+		t.Errorf("er1 should not be regarded as taken for pod1 itself")
+	}
+	if c.AssumedByOther("er3", "pod2") {
+		t.Errorf("er3 should not be reserved after confirming node1")
+	}
+	if err := c.Confirm("pod2", "node1", allocationPlan{"erc2": {"er2", "er4"}}); err == nil {
+		t.Errorf("pod2 should not be able to reserve er2")
+	}
+	if c.AssumedByOther("er4", "pod1") {
+		t.Errorf("a failed confirm must not reserve anything")
+	}
+
+	c.Release("pod1")
+	if c.AssumedByOther("er1", "pod2") {
+		t.Errorf("er1 should be released")
+	}
+
+	// reservations expire if the extended resources are never seen bound
+	if err := c.Confirm("pod2", "node1", allocationPlan{"erc2": {"er2"}}); err != nil {
+		t.Fatalf("confirm failed: %v", err)
+	}
+	c.AddPlan("pod3", "node2", allocationPlan{"erc3": {"er3"}})
+	now = now.Add(2 * time.Minute)
+	if c.AssumedByOther("er2", "pod3") {
+		t.Errorf("expired reservation should be ignored")
+	}
+	if _, ok := c.Plan("pod3", "node2"); ok {
+		t.Errorf("expired plan should be ignored")
+	}
+	c.cleanupExpired()
+	if len(c.reservations) != 0 || len(c.assumed) != 0 {
+		t.Errorf("expired reservations should be removed, got %v %v", c.reservations, c.assumed)
+	}
+}
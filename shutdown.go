@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// shutdownServer stops the server accepting new requests and waits for the requests in flight.
+// Two thirds of gracePeriod are given to the requests to finish; the binds still running then
+// are aborted by closing abortCh, and are waited for in the rest of gracePeriod while they roll back.
+// It returns false if requests are still in flight when gracePeriod is over.
+func shutdownServer(server *http.Server, handler *SchedulerHandler, abortCh chan<- struct{}, gracePeriod time.Duration) bool {
+	drainTimeout := gracePeriod * 2 / 3
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	// Shutdown returns nil once all connections are idle, i.e. no handler is running
+	err := server.Shutdown(ctx)
+	if err == nil {
+		return true
+	}
+
+	glog.Warningf("requests are still in flight after %v: %v, aborting the binds in flight", drainTimeout, err)
+	close(abortCh)
+	if !waitTimeout(&handler.inFlight, gracePeriod-drainTimeout) {
+		glog.Errorf("requests are still in flight after the shutdown grace period %v", gracePeriod)
+		return false
+	}
+	return true
+}
+
+// waitTimeout waits for wg to be done, it returns false if timeout passes first
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		// wg may be done at the same time
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownServer(t *testing.T) {
+	tests := []struct {
+		name string
+		// handle is the verb in flight when shutdown starts, it is given the abort channel
+		handle          func(abortCh <-chan struct{})
+		expectedAborted bool
+		expectedDrained bool
+	}{
+		{
+			name:            "request finishing in time",
+			handle:          func(abortCh <-chan struct{}) { time.Sleep(50 * time.Millisecond) },
+			expectedDrained: true,
+		},
+		{
+			name:            "bind aborted and rolled back",
+			handle:          func(abortCh <-chan struct{}) { <-abortCh },
+			expectedAborted: true,
+			expectedDrained: true,
+		},
+		{
+			name:            "request ignoring abort",
+			handle:          func(abortCh <-chan struct{}) { time.Sleep(time.Second) },
+			expectedAborted: true,
+			expectedDrained: false,
+		},
+	}
+	for _, test := range tests {
+		abortCh := make(chan struct{})
+		started := make(chan struct{})
+		mux = map[string]func(http.ResponseWriter, *http.Request){
+			"/scheduler/bind": func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				test.handle(abortCh)
+			},
+		}
+		handler := &SchedulerHandler{}
+		server := &http.Server{Handler: handler}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server.Serve(listener)
+		go http.Post("http://"+listener.Addr().String()+"/scheduler/bind", "application/json", nil)
+		<-started
+
+		drained := shutdownServer(server, handler, abortCh, 300*time.Millisecond)
+		aborted := false
+		select {
+		case <-abortCh:
+			aborted = true
+		default:
+		}
+		if aborted != test.expectedAborted {
+			t.Errorf("%s: expected aborted %v, got %v", test.name, test.expectedAborted, aborted)
+		}
+		if drained != test.expectedDrained {
+			t.Errorf("%s: expected drained %v, got %v", test.name, test.expectedDrained, drained)
+		}
+	}
+}
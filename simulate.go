@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+// simulateUsage is printed for simulate -h
+const simulateUsage = `usage: k8s-er-scheduler simulate [flags] <file or directory>...
+
+Places the pods found in the files one by one, in the order they are found, on the nodes found
+in the files, and prints the node and the extended resources each pod gets or why it can not be placed.
+Nodes, ExtendedResources, ExtendedResourceClaims and Pods are read from yaml or json files,
+several objects in a file are separated by "---". Other kinds are skipped.
+Only the extended resources are simulated, not the other predicates of kube-scheduler.
+
+`
+
+// simulationObjects are the objects a simulation starts from
+type simulationObjects struct {
+	nodes             []*v1.Node
+	extendedResources []*v1alpha1.ExtendedResource
+	claims            []*v1alpha1.ExtendedResourceClaim
+	pods              []*v1.Pod
+}
+
+// placement is the result of placing a pod in a simulation
+type placement struct {
+	pod  *v1.Pod
+	node string
+	plan allocationPlan
+	// bound is true if the pod had a node in the files
+	bound bool
+	// failure is why the pod can not be placed
+	failure string
+}
+
+// runSimulate runs the simulate subcommand with args, it returns the exit code
+func runSimulate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	priorityStrategy := flags.String("priority-strategy", NewDefaultConfig().PriorityStrategy, "strategy used to score nodes, binpack or spread")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, simulateUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if err := ValidatePriorityStrategy(*priorityStrategy); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	objects, err := loadSimulationObjects(flags.Args(), stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "load objects failed: %v\n", err)
+		return 1
+	}
+	placements := simulate(objects, *priorityStrategy)
+	printPlacements(stdout, placements)
+	return 0
+}
+
+// loadSimulationObjects reads the objects from the files, and the yaml and json files in the directories
+func loadSimulationObjects(paths []string, stderr io.Writer) (*simulationObjects, error) {
+	files, err := objectFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	objects := &simulationObjects{}
+	for _, file := range files {
+		documents, err := readDocuments(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, document := range documents {
+			if err := objects.add([]byte(document)); err != nil {
+				fmt.Fprintf(stderr, "skipping an object in %s: %v\n", file, err)
+			}
+		}
+	}
+	return objects, nil
+}
+
+// add decodes an object by its kind and adds it to objects
+func (o *simulationObjects) add(data []byte) error {
+	obj, typeMeta, err := decodeObject(data)
+	if err != nil {
+		return err
+	}
+	switch obj := obj.(type) {
+	case *v1.Node:
+		o.nodes = append(o.nodes, obj)
+	case *v1alpha1.ExtendedResource:
+		o.extendedResources = append(o.extendedResources, obj)
+	case *v1alpha1.ExtendedResourceClaim:
+		o.claims = append(o.claims, obj)
+	case *v1.Pod:
+		if obj.UID == "" {
+			obj.UID = types.UID("simulated-" + obj.Namespace + "-" + obj.Name)
+		}
+		o.pods = append(o.pods, obj)
+	default:
+		return fmt.Errorf("kind %q is not simulated", typeMeta.Kind)
+	}
+	return nil
+}
+
+// simulate places the pods in order with the same filter, prioritize and bind logic as the extender,
+// against an in-memory storage which every bind is written to
+func simulate(objects *simulationObjects, strategy string) []placement {
+	storage := NewMemoryStorage()
+	for _, er := range objects.extendedResources {
+		er = er.DeepCopy()
+		// extended resources written without a status are taken as available
+		if er.Status.Phase == "" {
+			er.Status.Phase = v1alpha1.ExtendedResourceAvailable
+		}
+		storage.Add(er)
+	}
+	for _, node := range objects.nodes {
+		node = node.DeepCopy()
+		// nodes written without allocatable extended resources get those whose node affinity they match
+		if len(node.Status.ExtendedResourceAllocatable) == 0 {
+			for _, er := range objects.extendedResources {
+				if er.Spec.NodeAffinity != nil && er.Spec.NodeAffinity.Required != nil && extendedResourceMatchesNode(er, node) {
+					node.Status.ExtendedResourceAllocatable = append(node.Status.ExtendedResourceAllocatable, er.Name)
+				}
+			}
+		}
+		storage.Add(node)
+	}
+	for _, erc := range objects.claims {
+		storage.Add(erc)
+	}
+	for _, pod := range objects.pods {
+		storage.Add(pod)
+	}
+	extendedResourceScheduler := &ExtendedResourceScheduler{Storage: storage}
+
+	placements := make([]placement, 0, len(objects.pods))
+	for _, pod := range objects.pods {
+		if pod.Spec.NodeName != "" {
+			placements = append(placements, placement{pod: pod, node: pod.Spec.NodeName, bound: true})
+			continue
+		}
+		placements = append(placements, place(pod, extendedResourceScheduler, strategy))
+	}
+	return placements
+}
+
+// place runs filter, prioritize and bind for pod
+func place(pod *v1.Pod, extendedResourceScheduler *ExtendedResourceScheduler, strategy string) placement {
+	result := placement{pod: pod}
+	nodes := make([]v1.Node, 0)
+	list, err := extendedResourceScheduler.Storage.ListNodes()
+	if err != nil {
+		result.failure = err.Error()
+		return result
+	}
+	for _, node := range list {
+		nodes = append(nodes, *node)
+	}
+
+	feasible, failedNodes, err := filterNodes(*pod, nodes, extendedResourceScheduler)
+	if err != nil {
+		result.failure = err.Error()
+		return result
+	}
+	if len(feasible) == 0 {
+		result.failure = summarizeFailures(failedNodes)
+		return result
+	}
+
+	// the node with the highest score is chosen, the first by name among equal scores
+	priorities := prioritize(schedulerapi.ExtenderArgs{Pod: *pod, Nodes: &v1.NodeList{Items: feasible}}, extendedResourceScheduler, strategy)
+	best := (*priorities)[0]
+	for _, priority := range *priorities {
+		if priority.Score > best.Score {
+			best = priority
+		}
+	}
+
+	bindingResult := bind(schedulerapi.ExtenderBindingArgs{
+		PodName:      pod.Name,
+		PodNamespace: pod.Namespace,
+		PodUID:       pod.UID,
+		Node:         best.Host,
+	}, extendedResourceScheduler)
+	if bindingResult.Error != "" {
+		result.failure = bindingResult.Error
+		return result
+	}
+
+	// the claims now name all extended resources bound to them
+	extendedResourceClaims, err := extendedResourceScheduler.FindExtendedResourceClaimList(*pod)
+	if err != nil {
+		result.failure = err.Error()
+		return result
+	}
+	result.plan = make(allocationPlan, len(extendedResourceClaims))
+	for _, erc := range extendedResourceClaims {
+		result.plan[erc.Name] = erc.Spec.ExtendedResourceNames
+	}
+	result.node = best.Host
+	return result
+}
+
+// printPlacements prints a line for every pod, and how many of the pods are placed
+func printPlacements(w io.Writer, placements []placement) {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "POD\tNODE\tEXTENDED RESOURCES")
+	placed := 0
+	for _, p := range placements {
+		name := p.pod.Namespace + "/" + p.pod.Name
+		switch {
+		case p.failure != "":
+			fmt.Fprintf(tw, "%s\t<none>\t%s\n", name, p.failure)
+		case p.bound:
+			placed++
+			fmt.Fprintf(tw, "%s\t%s\t(already bound)\n", name, p.node)
+		default:
+			placed++
+			claims := make([]string, 0, len(p.plan))
+			for ercName, erNames := range p.plan {
+				claims = append(claims, fmt.Sprintf("%s=%s", ercName, strings.Join(erNames, ",")))
+			}
+			sort.Strings(claims)
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, p.node, strings.Join(claims, " "))
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(&buf, "\n%d/%d pods placed\n", placed, len(placements))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSimulate(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runSimulate([]string{"examples/simulate"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	expected := []string{
+		"default/training    node2   training=gpu-node2-0,gpu-node2-1",
+		"default/inference   node1   inference=gpu-node1-0",
+		"default/training-2  <none>  0/2 nodes are available: 1 node: gpu-node2-0 gpu-node2-1 bound; " +
+			"1 node: not enough extended resources matching training-2",
+		"2/3 pods placed",
+	}
+	for _, line := range expected {
+		if !strings.Contains(stdout.String(), line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, stdout.String())
+		}
+	}
+
+	stderr.Reset()
+	if code := runSimulate([]string{"--priority-strategy", "random", "examples/simulate"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for an unknown strategy, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), `unsupported priority strategy "random"`) {
+		t.Errorf("expected the strategy to be rejected, got %q", stderr.String())
+	}
+}
+
+func TestLoadSimulationObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simulate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"objects.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: pod1
+---
+apiVersion: extendedresource.scheduler/v1alpha1
+kind: SchedulerConfiguration
+---
+apiVersion: extensions/v1alpha1
+kind: ExtendedResourceClaim
+metadata:
+  name: erc1
+  namespace: other
+`,
+		"node.json":  `{"apiVersion": "v1", "kind": "Node", "metadata": {"name": "node1"}}`,
+		"README.txt": "not an object",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stderr bytes.Buffer
+	objects, err := loadSimulationObjects([]string{dir}, &stderr)
+	if err != nil {
+		t.Fatalf("load objects failed: %v", err)
+	}
+	if len(objects.pods) != 1 || objects.pods[0].Namespace != "default" || objects.pods[0].UID == "" {
+		t.Errorf("expected pod1 in the default namespace with a uid, got %+v", objects.pods)
+	}
+	if len(objects.claims) != 1 || objects.claims[0].Namespace != "other" {
+		t.Errorf("expected erc1 in namespace other, got %+v", objects.claims)
+	}
+	if len(objects.nodes) != 1 {
+		t.Errorf("expected node1 from the json file, got %+v", objects.nodes)
+	}
+	if !strings.Contains(stderr.String(), `kind "SchedulerConfiguration" is not simulated`) {
+		t.Errorf("expected the configuration to be skipped, got %q", stderr.String())
+	}
+
+	if _, err := loadSimulationObjects([]string{filepath.Join(dir, "missing.yaml")}, &stderr); err == nil {
+		t.Errorf("expected a missing file to fail")
+	}
+}
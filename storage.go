@@ -0,0 +1,121 @@
+package main
+
+import (
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Storage reads and writes the objects the scheduler works on. Updates must fail with a conflict error
+// (apierrors.IsConflict) if the resourceVersion of the object is not the stored one, and get must
+// fail with a not found error (apierrors.IsNotFound) if the object does not exist.
+type Storage interface {
+	GetPod(namespace, name string) (*v1.Pod, error)
+	ListPods(namespace string) ([]*v1.Pod, error)
+	// Bind assigns the pod named by binding to the target node, it fails if the pod already has a node
+	Bind(namespace string, binding *v1.Binding) error
+
+	GetNode(name string) (*v1.Node, error)
+	ListNodes() ([]*v1.Node, error)
+	UpdateNodeStatus(node *v1.Node) (*v1.Node, error)
+
+	GetExtendedResource(name string) (*v1alpha1.ExtendedResource, error)
+	UpdateExtendedResource(er *v1alpha1.ExtendedResource) (*v1alpha1.ExtendedResource, error)
+
+	GetExtendedResourceClaim(namespace, name string) (*v1alpha1.ExtendedResourceClaim, error)
+	// ListExtendedResourceClaims lists the extendedresourceclaims of all namespaces
+	ListExtendedResourceClaims() ([]*v1alpha1.ExtendedResourceClaim, error)
+	UpdateExtendedResourceClaim(erc *v1alpha1.ExtendedResourceClaim) (*v1alpha1.ExtendedResourceClaim, error)
+}
+
+// apiServerStorage is the Storage backed by the kubernetes apiserver, every request is observed in the metrics
+type apiServerStorage struct {
+	clientset *kubernetes.Clientset
+}
+
+// NewAPIServerStorage creates a Storage talking to the apiserver through clientset
+func NewAPIServerStorage(clientset *kubernetes.Clientset) Storage {
+	return &apiServerStorage{clientset: clientset}
+}
+
+func (s *apiServerStorage) GetPod(namespace, name string) (*v1.Pod, error) {
+	defer observeAPIRequest("get", "pods", time.Now())
+	return s.clientset.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+}
+
+func (s *apiServerStorage) ListPods(namespace string) ([]*v1.Pod, error) {
+	defer observeAPIRequest("list", "pods", time.Now())
+	list, err := s.clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]*v1.Pod, 0, len(list.Items))
+	for i := range list.Items {
+		pods = append(pods, &list.Items[i])
+	}
+	return pods, nil
+}
+
+func (s *apiServerStorage) Bind(namespace string, binding *v1.Binding) error {
+	defer observeAPIRequest("create", "bindings", time.Now())
+	return s.clientset.CoreV1().Pods(namespace).Bind(binding)
+}
+
+func (s *apiServerStorage) GetNode(name string) (*v1.Node, error) {
+	defer observeAPIRequest("get", "nodes", time.Now())
+	return s.clientset.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+}
+
+func (s *apiServerStorage) ListNodes() ([]*v1.Node, error) {
+	defer observeAPIRequest("list", "nodes", time.Now())
+	list, err := s.clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*v1.Node, 0, len(list.Items))
+	for i := range list.Items {
+		nodes = append(nodes, &list.Items[i])
+	}
+	return nodes, nil
+}
+
+func (s *apiServerStorage) UpdateNodeStatus(node *v1.Node) (*v1.Node, error) {
+	defer observeAPIRequest("updatestatus", "nodes", time.Now())
+	return s.clientset.CoreV1().Nodes().UpdateStatus(node)
+}
+
+func (s *apiServerStorage) GetExtendedResource(name string) (*v1alpha1.ExtendedResource, error) {
+	defer observeAPIRequest("get", "extendedresources", time.Now())
+	return s.clientset.ExtensionsV1alpha1().ExtendedResources().Get(name, metav1.GetOptions{})
+}
+
+func (s *apiServerStorage) UpdateExtendedResource(er *v1alpha1.ExtendedResource) (*v1alpha1.ExtendedResource, error) {
+	defer observeAPIRequest("update", "extendedresources", time.Now())
+	return s.clientset.ExtensionsV1alpha1().ExtendedResources().Update(er)
+}
+
+func (s *apiServerStorage) GetExtendedResourceClaim(namespace, name string) (*v1alpha1.ExtendedResourceClaim, error) {
+	defer observeAPIRequest("get", "extendedresourceclaims", time.Now())
+	return s.clientset.ExtensionsV1alpha1().ExtendedResourceClaims(namespace).Get(name, metav1.GetOptions{})
+}
+
+func (s *apiServerStorage) ListExtendedResourceClaims() ([]*v1alpha1.ExtendedResourceClaim, error) {
+	defer observeAPIRequest("list", "extendedresourceclaims", time.Now())
+	list, err := s.clientset.ExtensionsV1alpha1().ExtendedResourceClaims(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	extendedResourceClaims := make([]*v1alpha1.ExtendedResourceClaim, 0, len(list.Items))
+	for i := range list.Items {
+		extendedResourceClaims = append(extendedResourceClaims, &list.Items[i])
+	}
+	return extendedResourceClaims, nil
+}
+
+func (s *apiServerStorage) UpdateExtendedResourceClaim(erc *v1alpha1.ExtendedResourceClaim) (*v1alpha1.ExtendedResourceClaim, error) {
+	defer observeAPIRequest("update", "extendedresourceclaims", time.Now())
+	return s.clientset.ExtensionsV1alpha1().ExtendedResourceClaims(erc.Namespace).Update(erc)
+}
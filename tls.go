@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// tlsCheckInterval is how often the certificate files are checked for changes
+const tlsCheckInterval = 10 * time.Second
+
+// TLSReloader serves the certificate and client CA bundle from files and reloads them when the files change,
+// so certificates can be rotated without restarting the server
+type TLSReloader struct {
+	config TLSConfig
+
+	lock       sync.Mutex
+	tlsConfig  *tls.Config
+	modTimes   []time.Time
+	lastCheck  time.Time
+	checkEvery time.Duration
+	now        func() time.Time
+}
+
+// NewTLSReloader loads the files of config, it fails if they can not be loaded at startup
+func NewTLSReloader(config TLSConfig) (*TLSReloader, error) {
+	r := &TLSReloader{
+		config:     config,
+		checkEvery: tlsCheckInterval,
+		now:        time.Now,
+	}
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.tlsConfig, r.modTimes, r.lastCheck = tlsConfig, modTimes, r.now()
+	return r, nil
+}
+
+// ServerTLSConfig returns the tls config for the http server, every connection gets the files loaded last
+func (r *TLSReloader) ServerTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+		// not used for handshakes since GetConfigForClient is set, it tells http.Server a certificate is configured
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.current().Certificates[0], nil
+		},
+	}
+}
+
+// current returns the tls config, reloading the files first if they changed since the last check.
+// If the changed files can not be loaded, e.g. the key is written after the certificate, the previous
+// config is kept and the files are loaded again at the next check.
+func (r *TLSReloader) current() *tls.Config {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.now()
+	if now.Sub(r.lastCheck) < r.checkEvery {
+		return r.tlsConfig
+	}
+	r.lastCheck = now
+
+	modTimes, err := r.stat()
+	if err != nil {
+		glog.Errorf("check tls files failed, keep serving the loaded certificate: %v", err)
+		return r.tlsConfig
+	}
+	if sameTimes(modTimes, r.modTimes) {
+		return r.tlsConfig
+	}
+	tlsConfig, err := r.load()
+	if err != nil {
+		glog.Errorf("reload tls files failed, keep serving the loaded certificate: %v", err)
+		return r.tlsConfig
+	}
+	glog.V(2).Info("tls files are reloaded")
+	r.tlsConfig, r.modTimes = tlsConfig, modTimes
+	return r.tlsConfig
+}
+
+func (r *TLSReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+func (r *TLSReloader) stat() ([]time.Time, error) {
+	modTimes := make([]time.Time, 0, 3)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+func (r *TLSReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate %s and key %s: %v", r.config.CertFile, r.config.KeyFile, err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if r.config.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	data, err := ioutil.ReadFile(r.config.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("load client ca %s: %v", r.config.ClientCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("load client ca %s: no certificate found", r.config.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	// probes and metrics scrapers have no client certificate, so it is only verified here when given
+	// and SchedulerHandler requires it for the extender verbs
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
+func sameTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := newTestCertificate(t, 1, nil, nil)
+	config := TLSConfig{
+		CertFile:     filepath.Join(dir, "tls.crt"),
+		KeyFile:      filepath.Join(dir, "tls.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	writeTestCertificate(t, config.ClientCAFile, "", ca, nil)
+	serverCert, serverKey := newTestCertificate(t, 2, ca, caKey)
+	writeTestCertificate(t, config.CertFile, config.KeyFile, serverCert, serverKey)
+	clientCert, clientKey := newTestCertificate(t, 3, ca, caKey)
+
+	reloader, err := NewTLSReloader(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+
+	mux = map[string]func(http.ResponseWriter, *http.Request){
+		"/scheduler/bind": func(w http.ResponseWriter, r *http.Request) {},
+	}
+	handler := &SchedulerHandler{
+		Endpoints:         map[string]http.HandlerFunc{healthzPath: Healthz()},
+		RequireClientCert: true,
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = reloader.ServerTLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	request := func(path string, withClientCert bool) (*http.Response, error) {
+		tlsConfig := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+		if withClientCert {
+			tlsConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return resp, nil
+	}
+	get := func(withClientCert bool) (*big.Int, error) {
+		resp, err := request("/scheduler/bind", withClientCert)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return resp.TLS.PeerCertificates[0].SerialNumber, nil
+	}
+
+	// the probes are served without a client certificate, the extender verbs are not
+	if resp, err := request(healthzPath, false); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("expected healthz to be served without a client certificate, got %v %v", resp, err)
+	}
+	if resp, err := request("/scheduler/bind", false); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the bind verb to be refused without a client certificate, got %v %v", resp, err)
+	}
+	serial, err := get(true)
+	if err != nil {
+		t.Fatalf("expected client with certificate to be served: %v", err)
+	}
+	if serial.Int64() != 2 {
+		t.Errorf("expected certificate serial 2, got %v", serial)
+	}
+
+	// rotate the certificate, it is served once the check interval passed
+	rotatedCert, rotatedKey := newTestCertificate(t, 4, ca, caKey)
+	writeTestCertificate(t, config.CertFile, config.KeyFile, rotatedCert, rotatedKey)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(config.CertFile, later, later)
+	if serial, _ := get(true); serial == nil || serial.Int64() != 2 {
+		t.Errorf("expected certificate serial 2 before the check interval, got %v", serial)
+	}
+	now = now.Add(tlsCheckInterval)
+	if serial, _ := get(true); serial == nil || serial.Int64() != 4 {
+		t.Errorf("expected rotated certificate serial 4, got %v", serial)
+	}
+
+	// a broken certificate is not loaded, the last one keeps being served
+	ioutil.WriteFile(config.CertFile, []byte("broken"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(config.CertFile, later, later)
+	now = now.Add(tlsCheckInterval)
+	if serial, _ := get(true); serial == nil || serial.Int64() != 4 {
+		t.Errorf("expected certificate serial 4 after a broken rotation, got %v", serial)
+	}
+}
+
+// newTestCertificate creates a CA certificate if parent is nil, otherwise a certificate for 127.0.0.1 signed by parent
+func newTestCertificate(t *testing.T, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writeTestCertificate(t *testing.T, certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
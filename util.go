@@ -4,24 +4,73 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1alpha1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+// updateBackoff is the backoff used to retry conflicted updates, the same as client-go DefaultBackoff
+var updateBackoff = wait.Backoff{
+	Steps:    4,
+	Duration: 10 * time.Millisecond,
+	Factor:   5.0,
+	Jitter:   0.1,
+}
+
 // ExtendedResourceScheduler is a set of methods that can find extendedresource and extendedresourceclaim
 type ExtendedResourceScheduler struct {
-	Clientset *kubernetes.Clientset
+	// Storage is where the objects are read from and written to, e.g. the apiserver
+	Storage Storage
+	// Cache is used for reads if it is set, otherwise reads go to Storage
+	Cache *ResourceCache
+	// Reservations keeps the allocation plans of filter and the extended resources reserved by bind, it is optional
+	Reservations *ReservationCache
+	// AbortCh is closed when the shutdown grace period is running out,
+	// binds in flight then roll back instead of going on
+	AbortCh <-chan struct{}
+	// Leader is set if leader election is enabled, only the leader writes extendedresources and claims
+	Leader *LeaderElector
+	// Recorder records the decisions as events, it is optional
+	Recorder *EventRecorder
+}
+
+// errShuttingDown is returned by binds aborted because the scheduler is shutting down
+var errShuttingDown = errors.New("scheduler is shutting down")
+
+// aborted returns an error once AbortCh is closed or the scheduler stops leading,
+// binds then roll back instead of going on
+func (e *ExtendedResourceScheduler) aborted() error {
+	select {
+	case <-e.AbortCh:
+		return errShuttingDown
+	default:
+	}
+	return e.checkLeader()
+}
+
+// checkLeader returns an error if leader election is enabled and this replica is not the leader
+func (e *ExtendedResourceScheduler) checkLeader() error {
+	if e.Leader == nil || e.Leader.IsLeader() {
+		return nil
+	}
+	return fmt.Errorf("this scheduler replica is not the leader, the leader is %q", e.Leader.Leader())
 }
 
 // FindExtendedResourceClaim find extendedresourceclaim by namespace and ercname
 func (e *ExtendedResourceScheduler) FindExtendedResourceClaim(namespace, ercName string) (*v1alpha1.ExtendedResourceClaim, error) {
-	erc, err := e.Clientset.ExtensionsV1alpha1().ExtendedResourceClaims(namespace).Get(ercName, metav1.GetOptions{})
+	var erc *v1alpha1.ExtendedResourceClaim
+	var err error
+	if e.Cache != nil {
+		erc, err = e.Cache.GetExtendedResourceClaim(namespace, ercName)
+	} else {
+		erc, err = e.Storage.GetExtendedResourceClaim(namespace, ercName)
+	}
 	if err != nil {
 		glog.Errorf("not found extendedresourceclaim: %v", err)
 		return nil, err
@@ -33,10 +82,52 @@ func (e *ExtendedResourceScheduler) FindExtendedResourceClaim(namespace, ercName
 	return erc, nil
 }
 
-// UpdateExtendedResourceClaim update extendedresourceclaim by namespace and erc
-func (e *ExtendedResourceScheduler) UpdateExtendedResourceClaim(namespace string, erc *v1alpha1.ExtendedResourceClaim) error {
-	_, err := e.Clientset.ExtensionsV1alpha1().ExtendedResourceClaims(namespace).Update(erc)
-	return err
+// UpdateExtendedResourceClaim writes the change made by mutate to the extendedresourceclaim using optimistic concurrency.
+// mutate is applied to a copy of erc first; if the write conflicts, the latest extendedresourceclaim is read
+// from Storage and mutate is applied to it again. mutate returns an error when the intended transition
+// is no longer valid, the update then stops and returns that error.
+func (e *ExtendedResourceScheduler) UpdateExtendedResourceClaim(erc *v1alpha1.ExtendedResourceClaim, mutate func(*v1alpha1.ExtendedResourceClaim) error) (*v1alpha1.ExtendedResourceClaim, error) {
+	current := erc.DeepCopy()
+	var updated *v1alpha1.ExtendedResourceClaim
+	err := retryOnConflict(func() error {
+		if err := mutate(current); err != nil {
+			return err
+		}
+		result, err := e.Storage.UpdateExtendedResourceClaim(current)
+		if err == nil {
+			updated = result
+			return nil
+		}
+		if apierrors.IsConflict(err) {
+			glog.V(3).Infof("update extendedresourceclaim %s/%s conflicted, reading the latest version", erc.Namespace, erc.Name)
+			latest, getErr := e.Storage.GetExtendedResourceClaim(erc.Namespace, erc.Name)
+			if getErr != nil {
+				return getErr
+			}
+			current = latest
+		}
+		return err
+	})
+	return updated, err
+}
+
+// FindPendingExtendedResourceClaims gets the extendedresourceclaims still waiting for extended resources
+func (e *ExtendedResourceScheduler) FindPendingExtendedResourceClaims() ([]*v1alpha1.ExtendedResourceClaim, error) {
+	if e.Cache != nil {
+		return append(e.Cache.ListExtendedResourceClaimsByPhase(""),
+			e.Cache.ListExtendedResourceClaimsByPhase(v1alpha1.ExtendedResourceClaimPending)...), nil
+	}
+	extendedResourceClaims, err := e.Storage.ListExtendedResourceClaims()
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]*v1alpha1.ExtendedResourceClaim, 0, len(extendedResourceClaims))
+	for _, erc := range extendedResourceClaims {
+		if erc.Status.Phase == "" || erc.Status.Phase == v1alpha1.ExtendedResourceClaimPending {
+			pending = append(pending, erc)
+		}
+	}
+	return pending, nil
 }
 
 // FindExtendedResourceList get a set of ExtendedResource
@@ -53,9 +144,30 @@ func (e *ExtendedResourceScheduler) FindExtendedResourceList(erNames []string) (
 	return extendedResources, nil
 }
 
+// FindExtendedResourcesOnNode gets the extendedresources allocatable on node,
+// from the cache at once if it is set. It fails if one of them is not found.
+func (e *ExtendedResourceScheduler) FindExtendedResourcesOnNode(node *v1.Node) ([]*v1alpha1.ExtendedResource, error) {
+	if e.Cache == nil {
+		return e.FindExtendedResourceList(node.Status.ExtendedResourceAllocatable)
+	}
+	extendedResources, missing := e.Cache.GetExtendedResources(node.Status.ExtendedResourceAllocatable)
+	if len(missing) > 0 {
+		err := newNotFoundError("extendedresource", missing[0])
+		glog.Errorf("find extendedresources on node %s: %v", node.Name, err)
+		return nil, err
+	}
+	return extendedResources, nil
+}
+
 // FindExtendedResource find extendedresource by ername
 func (e *ExtendedResourceScheduler) FindExtendedResource(erName string) (*v1alpha1.ExtendedResource, error) {
-	er, err := e.Clientset.ExtensionsV1alpha1().ExtendedResources().Get(erName, metav1.GetOptions{})
+	var er *v1alpha1.ExtendedResource
+	var err error
+	if e.Cache != nil {
+		er, err = e.Cache.GetExtendedResource(erName)
+	} else {
+		er, err = e.Storage.GetExtendedResource(erName)
+	}
 	if err != nil {
 		glog.Errorf("not found extendedresource by ername: %v", err)
 		return nil, err
@@ -63,15 +175,42 @@ func (e *ExtendedResourceScheduler) FindExtendedResource(erName string) (*v1alph
 	return er, nil
 }
 
-// UpdateExtendedResource update extendedresource
-func (e *ExtendedResourceScheduler) UpdateExtendedResource(er *v1alpha1.ExtendedResource) error {
-	_, err := e.Clientset.ExtensionsV1alpha1().ExtendedResources().Update(er)
-	return err
+// UpdateExtendedResource writes the change made by mutate to the extendedresource using optimistic concurrency,
+// see UpdateExtendedResourceClaim.
+func (e *ExtendedResourceScheduler) UpdateExtendedResource(er *v1alpha1.ExtendedResource, mutate func(*v1alpha1.ExtendedResource) error) (*v1alpha1.ExtendedResource, error) {
+	current := er.DeepCopy()
+	var updated *v1alpha1.ExtendedResource
+	err := retryOnConflict(func() error {
+		if err := mutate(current); err != nil {
+			return err
+		}
+		result, err := e.Storage.UpdateExtendedResource(current)
+		if err == nil {
+			updated = result
+			return nil
+		}
+		if apierrors.IsConflict(err) {
+			glog.V(3).Infof("update extendedresource %s conflicted, reading the latest version", er.Name)
+			latest, getErr := e.Storage.GetExtendedResource(er.Name)
+			if getErr != nil {
+				return getErr
+			}
+			current = latest
+		}
+		return err
+	})
+	return updated, err
 }
 
 // FindNode is get node
 func (e *ExtendedResourceScheduler) FindNode(name string) (*v1.Node, error) {
-	node, err := e.Clientset.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	var node *v1.Node
+	var err error
+	if e.Cache != nil {
+		node, err = e.Cache.GetNode(name)
+	} else {
+		node, err = e.Storage.GetNode(name)
+	}
 	if err != nil {
 		glog.Errorf("find node failed: %v", err)
 		return nil, err
@@ -81,7 +220,7 @@ func (e *ExtendedResourceScheduler) FindNode(name string) (*v1.Node, error) {
 
 // UpdateNodeStatus is used to update node status object
 func (e *ExtendedResourceScheduler) updateNodeStatus(node *v1.Node) error {
-	_, err := e.Clientset.CoreV1().Nodes().UpdateStatus(node)
+	_, err := e.Storage.UpdateNodeStatus(node)
 	if err != nil {
 		glog.Errorf("update node failed: %v", err)
 		return err
@@ -91,7 +230,13 @@ func (e *ExtendedResourceScheduler) updateNodeStatus(node *v1.Node) error {
 
 // FindPod is get pod by name and namespace
 func (e *ExtendedResourceScheduler) FindPod(name, namespace string) (*v1.Pod, error) {
-	pod, err := e.Clientset.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+	var pod *v1.Pod
+	var err error
+	if e.Cache != nil {
+		pod, err = e.Cache.GetPod(namespace, name)
+	} else {
+		pod, err = e.Storage.GetPod(namespace, name)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -100,7 +245,7 @@ func (e *ExtendedResourceScheduler) FindPod(name, namespace string) (*v1.Pod, er
 
 // Bind is assign pod to node
 func (e *ExtendedResourceScheduler) Bind(namespace string, b *v1.Binding) error {
-	err := e.Clientset.CoreV1().Pods(namespace).Bind(b)
+	err := e.Storage.Bind(namespace, b)
 	if err != nil {
 		glog.Errorf("bind failed: %v", err)
 		return err
@@ -130,20 +275,6 @@ func (e *ExtendedResourceScheduler) FindExtendedResourceClaimList(pod v1.Pod) ([
 	return extendedResourceClaims, nil
 }
 
-// whether properties contain all labels, if contain all then return true, or return false
-func mapInMap(labels, properties map[string]string) bool {
-	if len(labels) == 0 {
-		return true
-	}
-	for k, v := range labels {
-		if vv, ok := properties[k]; ok && v == vv {
-			return true
-		}
-		return false
-	}
-	return false
-}
-
 // target whether contain all s slice, if not, return exclusive value and false
 func sliceInSlice(s, target []string) ([]string, bool) {
 	re := make([]string, 0)
@@ -159,39 +290,63 @@ func sliceInSlice(s, target []string) ([]string, bool) {
 	return nil, true
 }
 
-func labelMatchesLabelSelectorExpressions(matchExpressions []metav1.LabelSelectorRequirement, mLabels map[string]string) bool {
-	if len(matchExpressions) == 0 {
-		return true
-	}
-	labelSelector, err := labelSelectorRequirementsAsSelector(matchExpressions)
-	if err != nil {
-		glog.V(3).Infof("Failed to parse MatchExpressions: %+v, regarding as not match.", matchExpressions)
-		return false
+// whether s contains target
+func containsString(s []string, target string) bool {
+	for _, ele := range s {
+		if ele == target {
+			return true
+		}
 	}
-	if labelSelector.Matches(labels.Set(mLabels)) {
+	return false
+}
+
+// extendedResourceMatchesNode checks whether the node satisfies the required node affinity of the extended resource.
+// An extended resource without required node affinity matches every node.
+func extendedResourceMatchesNode(er *v1alpha1.ExtendedResource, node *v1.Node) bool {
+	if er.Spec.NodeAffinity == nil || er.Spec.NodeAffinity.Required == nil {
 		return true
 	}
+	return nodeMatchesNodeSelectorTerms(node, er.Spec.NodeAffinity.Required.NodeSelectorTerms)
+}
+
+// nodeMatchesNodeSelectorTerms checks if a node's labels satisfy a list of node selector terms,
+// terms are ORed, and an empty list of terms will match nothing.
+func nodeMatchesNodeSelectorTerms(node *v1.Node, nodeSelectorTerms []v1.NodeSelectorTerm) bool {
+	for _, req := range nodeSelectorTerms {
+		nodeSelector, err := nodeSelectorRequirementsAsSelector(req.MatchExpressions)
+		if err != nil {
+			glog.V(3).Infof("Failed to parse MatchExpressions: %+v, regarding as not match.", req.MatchExpressions)
+			return false
+		}
+		if nodeSelector.Matches(labels.Set(node.Labels)) {
+			return true
+		}
+	}
 	return false
 }
 
-func labelSelectorRequirementsAsSelector(lsr []metav1.LabelSelectorRequirement) (labels.Selector, error) {
-	if len(lsr) == 0 {
+func nodeSelectorRequirementsAsSelector(nsm []v1.NodeSelectorRequirement) (labels.Selector, error) {
+	if len(nsm) == 0 {
 		return labels.Nothing(), nil
 	}
 	selector := labels.NewSelector()
-	for _, expr := range lsr {
+	for _, expr := range nsm {
 		var op selection.Operator
 		switch expr.Operator {
-		case metav1.LabelSelectorOpIn:
+		case v1.NodeSelectorOpIn:
 			op = selection.In
-		case metav1.LabelSelectorOpNotIn:
+		case v1.NodeSelectorOpNotIn:
 			op = selection.NotIn
-		case metav1.LabelSelectorOpExists:
+		case v1.NodeSelectorOpExists:
 			op = selection.Exists
-		case metav1.LabelSelectorOpDoesNotExist:
+		case v1.NodeSelectorOpDoesNotExist:
 			op = selection.DoesNotExist
+		case v1.NodeSelectorOpGt:
+			op = selection.GreaterThan
+		case v1.NodeSelectorOpLt:
+			op = selection.LessThan
 		default:
-			return nil, fmt.Errorf("%q is not a valid label selector operator", expr.Operator)
+			return nil, fmt.Errorf("%q is not a valid node selector operator", expr.Operator)
 		}
 		r, err := labels.NewRequirement(expr.Key, op, expr.Values)
 		if err != nil {
@@ -201,3 +356,25 @@ func labelSelectorRequirementsAsSelector(lsr []metav1.LabelSelectorRequirement)
 	}
 	return selector, nil
 }
+
+// retryOnConflict runs fn until it succeeds or returns an error other than conflict,
+// waiting with updateBackoff between attempts. The last conflict error is returned if all attempts conflicted.
+func retryOnConflict(fn func() error) error {
+	var lastConflictErr error
+	err := wait.ExponentialBackoff(updateBackoff, func() (bool, error) {
+		err := fn()
+		switch {
+		case err == nil:
+			return true, nil
+		case apierrors.IsConflict(err):
+			lastConflictErr = err
+			return false, nil
+		default:
+			return false, err
+		}
+	})
+	if err == wait.ErrWaitTimeout {
+		err = lastConflictErr
+	}
+	return err
+}
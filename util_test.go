@@ -1,7 +1,14 @@
 package main
 
 import (
+	"errors"
 	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 func TestUpdateNode(t *testing.T) {
@@ -12,7 +19,7 @@ func TestUpdateNode(t *testing.T) {
 		t.Fatalf("create clientset failed: %v\n", err)
 	}
 	ers := &ExtendedResourceScheduler{
-		Clientset: clientset,
+		Storage: NewAPIServerStorage(clientset),
 	}
 	node, err := ers.FindNode("127.0.0.1")
 	if err != nil {
@@ -34,7 +41,7 @@ func TestUpdateNodeER7(t *testing.T) {
 		t.Fatalf("create clientset failed: %v\n", err)
 	}
 	ers := &ExtendedResourceScheduler{
-		Clientset: clientset,
+		Storage: NewAPIServerStorage(clientset),
 	}
 	node, err := ers.FindNode("127.0.0.1")
 	if err != nil {
@@ -56,7 +63,7 @@ func TestCleanNodeER(t *testing.T) {
 		t.Fatalf("create clientset failed: %v\n", err)
 	}
 	ers := &ExtendedResourceScheduler{
-		Clientset: clientset,
+		Storage: NewAPIServerStorage(clientset),
 	}
 	node, err := ers.FindNode("127.0.0.1")
 	if err != nil {
@@ -77,7 +84,7 @@ func TestPrintNodeAllocatable(t *testing.T) {
 		t.Fatalf("create clientset failed: %v\n", err)
 	}
 	ers := &ExtendedResourceScheduler{
-		Clientset: clientset,
+		Storage: NewAPIServerStorage(clientset),
 	}
 	node, err := ers.FindNode("127.0.0.1")
 	if err != nil {
@@ -85,3 +92,150 @@ func TestPrintNodeAllocatable(t *testing.T) {
 	}
 	t.Errorf("node: %v", node.Status.ExtendedResourceAllocatable)
 }
+
+func TestExtendedResourceMatchesNode(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "127.0.0.1",
+			Labels: map[string]string{"kubernetes.io/hostname": "127.0.0.1", "gpu": "k80"},
+		},
+	}
+	hostnameIn := func(values ...string) v1.NodeSelectorRequirement {
+		return v1.NodeSelectorRequirement{Key: "kubernetes.io/hostname", Operator: v1.NodeSelectorOpIn, Values: values}
+	}
+	tests := []struct {
+		name     string
+		affinity *v1alpha1.ResourceNodeAffinity
+		expected bool
+	}{
+		{
+			name:     "no affinity",
+			affinity: nil,
+			expected: true,
+		},
+		{
+			name:     "no required affinity",
+			affinity: &v1alpha1.ResourceNodeAffinity{},
+			expected: true,
+		},
+		{
+			name:     "empty terms match nothing",
+			affinity: &v1alpha1.ResourceNodeAffinity{Required: &v1.NodeSelector{}},
+			expected: false,
+		},
+		{
+			name: "hostname matches",
+			affinity: &v1alpha1.ResourceNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{hostnameIn("127.0.0.1")}},
+			}}},
+			expected: true,
+		},
+		{
+			name: "hostname does not match",
+			affinity: &v1alpha1.ResourceNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{hostnameIn("127.0.0.2")}},
+			}}},
+			expected: false,
+		},
+		{
+			name: "expressions in a term are ANDed",
+			affinity: &v1alpha1.ResourceNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{
+					hostnameIn("127.0.0.1"),
+					{Key: "gpu", Operator: v1.NodeSelectorOpNotIn, Values: []string{"k80"}},
+				}},
+			}}},
+			expected: false,
+		},
+		{
+			name: "terms are ORed",
+			affinity: &v1alpha1.ResourceNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{hostnameIn("127.0.0.2")}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "gpu", Operator: v1.NodeSelectorOpExists}}},
+			}}},
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		er := &v1alpha1.ExtendedResource{Spec: v1alpha1.ExtendedResourceSpec{NodeAffinity: test.affinity}}
+		if got := extendedResourceMatchesNode(er, node); got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestRetryOnConflict(t *testing.T) {
+	conflict := apierrors.NewConflict(schema.GroupResource{Resource: "extendedresources"}, "er1", errors.New("modified"))
+	invalid := errors.New("extendedresource er1 is no longer available")
+	tests := []struct {
+		name          string
+		results       []error
+		expectedErr   error
+		expectedCalls int
+	}{
+		{
+			name:          "succeed at once",
+			results:       []error{nil},
+			expectedCalls: 1,
+		},
+		{
+			name:          "succeed after conflicts",
+			results:       []error{conflict, conflict, nil},
+			expectedCalls: 3,
+		},
+		{
+			name:          "stop when transition is invalid",
+			results:       []error{conflict, invalid},
+			expectedErr:   invalid,
+			expectedCalls: 2,
+		},
+		{
+			name:          "always conflict",
+			results:       []error{conflict, conflict, conflict, conflict},
+			expectedErr:   conflict,
+			expectedCalls: updateBackoff.Steps,
+		},
+	}
+	for _, test := range tests {
+		calls := 0
+		err := retryOnConflict(func() error {
+			err := test.results[calls]
+			calls++
+			return err
+		})
+		if err != test.expectedErr {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.expectedErr, err)
+		}
+		if calls != test.expectedCalls {
+			t.Errorf("%s: expected %d calls, got %d", test.name, test.expectedCalls, calls)
+		}
+	}
+}
+
+func TestUpdateExtendedResourceConflict(t *testing.T) {
+	storage := NewMemoryStorage()
+	if err := storage.Add(&v1alpha1.ExtendedResource{ObjectMeta: metav1.ObjectMeta{Name: "er1"}}); err != nil {
+		t.Fatal(err)
+	}
+	ers := &ExtendedResourceScheduler{Storage: storage}
+	stale, _ := storage.GetExtendedResource("er1")
+	latest := stale.DeepCopy()
+	latest.Spec.DeviceID = "gpu0"
+	if _, err := storage.UpdateExtendedResource(latest); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale copy conflicts, the change is applied again to the latest er1
+	calls := 0
+	updated, err := ers.UpdateExtendedResource(stale, func(er *v1alpha1.ExtendedResource) error {
+		calls++
+		er.Status.Phase = v1alpha1.ExtendedResourceBound
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if calls != 2 || updated.Spec.DeviceID != "gpu0" || updated.Status.Phase != v1alpha1.ExtendedResourceBound {
+		t.Errorf("expected er1 bound with the device of the latest version after 2 calls, got %d calls and %+v", calls, updated)
+	}
+}